@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+)
+
+// BASIC_AUTH_REALM is the realm sent to clients which have not authenticated.
+const BASIC_AUTH_REALM string = "net-test"
+
+// basicAuth wraps next so requests must provide HTTP Basic Auth credentials matching user and pass.
+func basicAuth(user string, pass string, next http.Handler) http.Handler {
+	// Compare hashes so the comparison takes the same time regardless of the credential lengths
+	userHash := sha256.Sum256([]byte(user))
+	passHash := sha256.Sum256([]byte(pass))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqUser, reqPass, ok := r.BasicAuth()
+		reqUserHash := sha256.Sum256([]byte(reqUser))
+		reqPassHash := sha256.Sum256([]byte(reqPass))
+
+		userMatch := subtle.ConstantTimeCompare(userHash[:], reqUserHash[:]) == 1
+		passMatch := subtle.ConstantTimeCompare(passHash[:], reqPassHash[:]) == 1
+
+		if !ok || !userMatch || !passMatch {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+BASIC_AUTH_REALM+`", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// MAX_PING_BACKOFF_MS is the longest number of milliseconds between measurements of a repeatedly
+// failing target host. 5 minutes.
+const MAX_PING_BACKOFF_MS int = 300000
+
+// hostBackoff is the backoff state of a single target host.
+type hostBackoff struct {
+	// failures is the number of consecutive failed measurements.
+	failures int
+
+	// skips is the number of upcoming measurements which will be skipped.
+	skips int
+}
+
+// backoffTracker tracks consecutive failures of target hosts so repeatedly failing hosts are
+// measured exponentially less often. Measurements are skipped rather than rescheduled so the same
+// logic applies whether hosts share an interval or have their own.
+type backoffTracker struct {
+	lock  sync.Mutex
+	hosts map[string]*hostBackoff
+}
+
+// newBackoffTracker creates a backoffTracker with no failing hosts.
+func newBackoffTracker() *backoffTracker {
+	return &backoffTracker{
+		hosts: map[string]*hostBackoff{},
+	}
+}
+
+// skip indicates if the current measurement of host should be skipped as it is backing off.
+func (b *backoffTracker) skip(host string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	state, ok := b.hosts[host]
+	if !ok || state.skips == 0 {
+		return false
+	}
+
+	state.skips--
+
+	return true
+}
+
+// failed records a failed measurement of host, which is measured every intervalMs, and returns the
+// consecutive failures and the additional time until it will be measured again. The time between
+// measurements doubles with each consecutive failure up to MAX_PING_BACKOFF_MS.
+func (b *backoffTracker) failed(host string, intervalMs int) (int, time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	state, ok := b.hosts[host]
+	if !ok {
+		state = &hostBackoff{}
+		b.hosts[host] = state
+	}
+
+	state.failures++
+
+	// Measured every 2^(failures-1) intervals, the shift is bounded so it cannot overflow
+	maxSkips := max(MAX_PING_BACKOFF_MS/max(intervalMs, 1)-1, 0)
+	state.skips = min(1<<min(state.failures-1, 30)-1, maxSkips) //nolint:mnd
+
+	return state.failures, time.Duration(state.skips*intervalMs) * time.Millisecond
+}
+
+// consecutiveFailures returns the number of consecutive failed measurements of host.
+func (b *backoffTracker) consecutiveFailures(host string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if state, ok := b.hosts[host]; ok {
+		return state.failures
+	}
+
+	return 0
+}
+
+// succeeded records a successful measurement of host, which resets its backoff.
+func (b *backoffTracker) succeeded(host string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.hosts, host)
+}
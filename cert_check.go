@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/proxy"
+)
+
+// CERT_MEASUREMENT is the type of certificate check measurements.
+const CERT_MEASUREMENT string = "cert"
+
+// DEFAULT_CERT_CHECK_INTERVAL_MS is the default number of milliseconds between certificate checks,
+// certificates change rarely. 1 hour.
+const DEFAULT_CERT_CHECK_INTERVAL_MS int = 3600000
+
+// CERT_CHECK_TIMEOUT_MS is the number of milliseconds before connecting and completing the TLS
+// handshake of a certificate check will timeout. 10 seconds.
+const CERT_CHECK_TIMEOUT_MS int = 10000
+
+// parseCertTargets parses "host:port" values into the targets of certificate checks.
+func parseCertTargets(values []string) ([]tcpTarget, error) {
+	targets := make([]tcpTarget, 0, len(values))
+	for _, value := range values {
+		host, port, err := net.SplitHostPort(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate check target \"%s\": %w", value, err)
+		}
+
+		if len(host) == 0 || len(port) == 0 {
+			return nil, fmt.Errorf(
+				"invalid certificate check target \"%s\": must be in the form host:port",
+				value,
+			)
+		}
+
+		targets = append(targets, tcpTarget{
+			host: host,
+			port: port,
+		})
+	}
+
+	return targets, nil
+}
+
+// certMeasurer periodically reads the certificate chain presented by targets and records when each
+// certificate expires and whether the chain is valid.
+type certMeasurer struct {
+	// targets are the hosts and ports to read the certificates of.
+	targets []tcpTarget
+
+	// intervalMs is the number of milliseconds to wait between measurements.
+	intervalMs int
+
+	// dialer opens connections, directly or through a proxy.
+	dialer proxy.ContextDialer
+
+	// heartbeat is beat after every measurement.
+	heartbeat *heartbeat
+
+	// intervalJitter randomizes the time between measurements.
+	intervalJitter intervalJitter
+
+	// compensateDrift starts measurements on a fixed cadence rather than waiting the interval after
+	// each one finishes.
+	compensateDrift bool
+
+	// failureLog collapses repeated warnings about persistently failing targets.
+	failureLog *failureLogger
+
+	expiry   *prom.GaugeVec
+	valid    *prom.GaugeVec
+	failures *prom.CounterVec
+}
+
+// newCertMeasurer creates a certMeasurer and registers its Prometheus metrics.
+func newCertMeasurer(
+	targets []tcpTarget,
+	intervalMs int,
+	dialer proxy.ContextDialer,
+	metrics metricsOptions,
+) *certMeasurer {
+	m := &certMeasurer{
+		targets:    targets,
+		intervalMs: intervalMs,
+		dialer:     dialer,
+		failureLog: newFailureLogger(),
+		expiry: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: metrics.namespace,
+				Name:      "cert_expiry_timestamp_seconds",
+				Help:      "Unix timestamp at which each certificate in the chain presented by a target host and port expires",
+			},
+			[]string{"target_host", "port", "cn"},
+		),
+		valid: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: metrics.namespace,
+				Name:      "cert_valid",
+				Help:      "Whether the certificate chain presented by a target host and port was valid for the target host in the last check, 1 if valid, otherwise 0",
+			},
+			[]string{"target_host", "port", "cn"},
+		),
+		failures: prom.NewCounterVec(
+			prom.CounterOpts{
+				Namespace: metrics.namespace,
+				Name:      "cert_check_failures_total",
+				Help:      "Failures to read the certificates of target hosts and ports",
+			},
+			[]string{"target_host", "port"},
+		),
+	}
+
+	prom.MustRegister(m.expiry)
+	prom.MustRegister(m.valid)
+	prom.MustRegister(m.failures)
+
+	return m
+}
+
+// run performs measurements until ctx is done, waiting for the interval between each.
+func (m *certMeasurer) run(ctx context.Context) {
+	timer := newIntervalTimer(
+		CERT_MEASUREMENT,
+		time.Duration(m.intervalMs)*time.Millisecond,
+		m.intervalJitter,
+		m.compensateDrift,
+	)
+	defer timer.stop()
+
+	for {
+		start := time.Now()
+		m.measure(ctx)
+		m.heartbeat.finished(start)
+
+		if !timer.wait(ctx) {
+			return
+		}
+	}
+}
+
+// measureAll checks the certificates of every target once.
+func (m *certMeasurer) measureAll(ctx context.Context) []measurement {
+	return m.measure(ctx)
+}
+
+// measure checks the certificates of each target once.
+func (m *certMeasurer) measure(ctx context.Context) []measurement {
+	results := []measurement{}
+	for _, target := range m.targets {
+		result, ok := m.measureTarget(ctx, target)
+		if !ok {
+			return results
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// measureTarget reads and checks the certificate chain of target once, an invalid chain is a failed
+// measurement. It indicates if the measurement completed, rather than being interrupted by ctx.
+func (m *certMeasurer) measureTarget(ctx context.Context, target tcpTarget) (measurement, bool) {
+	labels := prom.Labels{
+		"target_host": target.host,
+		"port":        target.port,
+	}
+	addr := net.JoinHostPort(target.host, target.port)
+
+	start := time.Now()
+	certificates, err := m.readCertificates(ctx, target)
+	elapsed := time.Since(start)
+	if ctx.Err() != nil {
+		// Shutting down, the measurement was interrupted so don't record it
+		return measurement{}, false
+	}
+	if err != nil {
+		m.failureLog.failed(
+			addr,
+			"failed to read certificates",
+			"target_host", target.host,
+			"port", target.port,
+			"error", err,
+		)
+		m.failures.With(labels).Inc()
+		return failedMeasurement(CERT_MEASUREMENT, addr, err), true
+	}
+
+	// The certificates may have been replaced since the last check, so forget their common names
+	m.expiry.DeletePartialMatch(labels)
+	m.valid.DeletePartialMatch(labels)
+
+	for _, certificate := range certificates {
+		m.expiry.With(prom.Labels{
+			"target_host": target.host,
+			"port":        target.port,
+			"cn":          certificate.Subject.CommonName,
+		}).Set(float64(certificate.NotAfter.Unix()))
+	}
+
+	// The leaf certificate is first, the remainder are intermediates it may be verified through
+	leaf := certificates[0]
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+
+	valid := 1.0
+	_, verifyErr := leaf.Verify(x509.VerifyOptions{
+		DNSName:       target.host,
+		Intermediates: intermediates,
+	})
+	if verifyErr != nil {
+		valid = 0
+		slog.Warn(
+			"certificate is not valid",
+			"target_host", target.host,
+			"port", target.port,
+			"cn", leaf.Subject.CommonName,
+			"error", verifyErr,
+		)
+	}
+
+	m.valid.With(prom.Labels{
+		"target_host": target.host,
+		"port":        target.port,
+		"cn":          leaf.Subject.CommonName,
+	}).Set(valid)
+
+	m.failureLog.succeeded(addr, "target_host", target.host, "port", target.port)
+	slog.Debug(
+		"certificate checked",
+		"target_host", target.host,
+		"port", target.port,
+		"cn", leaf.Subject.CommonName,
+		"expires", leaf.NotAfter,
+		"valid", verifyErr == nil,
+	)
+
+	if verifyErr != nil {
+		return failedMeasurement(CERT_MEASUREMENT, addr, verifyErr), true
+	}
+
+	return successfulMeasurement(CERT_MEASUREMENT, addr, durationMs(elapsed)), true
+}
+
+// readCertificates connects to target and completes a TLS handshake using the target host as the
+// server name, returning the certificate chain it presents. The chain is not verified so expired or
+// otherwise invalid certificates are still returned.
+func (m *certMeasurer) readCertificates(
+	ctx context.Context,
+	target tcpTarget,
+) ([]*x509.Certificate, error) {
+	dialCtx, cancel := context.WithTimeout(
+		ctx,
+		time.Duration(CERT_CHECK_TIMEOUT_MS)*time.Millisecond,
+	)
+	defer cancel()
+
+	conn, err := m.dialer.DialContext(dialCtx, "tcp", net.JoinHostPort(target.host, target.port))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName: target.host,
+		// Verified separately so invalid certificates are recorded rather than failing
+		InsecureSkipVerify: true, //nolint:gosec
+	})
+	if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+		return nil, err
+	}
+
+	certificates := tlsConn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return nil, errors.New("no certificates presented")
+	}
+
+	return certificates, nil
+}
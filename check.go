@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/url"
+	"time"
+)
+
+// checkHosts resolves each DNS name in hosts once, logging an error for each which does not
+// resolve, and indicates if all resolved. IP addresses are not resolved.
+func checkHosts(ctx context.Context, hosts []string) bool {
+	allResolved := true
+	checked := map[string]bool{}
+	for _, host := range hosts {
+		key := targetKey(host)
+		if checked[key] || net.ParseIP(host) != nil {
+			continue
+		}
+		checked[key] = true
+
+		resolveCtx, cancel := context.WithTimeout(
+			ctx,
+			time.Duration(DNS_RESOLVE_TIMEOUT_MS)*time.Millisecond,
+		)
+		addrs, err := net.DefaultResolver.LookupHost(resolveCtx, host)
+		cancel()
+		if err != nil {
+			slog.Error("target host does not resolve", "target_host", host, "error", err)
+			allResolved = false
+			continue
+		}
+
+		slog.Info("target host resolves", "target_host", host, "addresses", addrs)
+	}
+
+	return allResolved
+}
+
+// httpHosts returns the host of each of urls, which must already be valid.
+func httpHosts(urls []string) []string {
+	hosts := make([]string, 0, len(urls))
+	for _, value := range urls {
+		if u, err := url.Parse(value); err == nil {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+
+	return hosts
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the structure of the YAML configuration file provided via -config. Every field is
+// optional, fields which are not set leave the corresponding command line option at its default.
+type Config struct {
+	// Targets are the hosts to measure, in order.
+	Targets []TargetConfig `yaml:"targets"`
+
+	// MetricsHost is the host on which to serve Prometheus metrics (see -m).
+	MetricsHost string `yaml:"metrics_host"`
+
+	// PingIntervalMs is the interval in milliseconds at which to ping (see -p).
+	PingIntervalMs *int `yaml:"ping_interval_ms"`
+
+	// PingCount is the number of ping packets sent per measurement (see -c).
+	PingCount *int `yaml:"ping_count"`
+
+	// Fallover enables the fallover host picking strategy (see -f).
+	Fallover *bool `yaml:"fallover"`
+
+	// All enables the measure all hosts picking strategy (see -a).
+	All *bool `yaml:"all"`
+
+	// MaxConcurrency is the maximum number of pings run at the same time (see -max-concurrency).
+	MaxConcurrency *int `yaml:"max_concurrency"`
+}
+
+// TargetConfig is a target host and the options which override the global values for that host.
+type TargetConfig struct {
+	// Host is the DNS name or IP address to measure.
+	Host string `yaml:"host"`
+
+	// Count overrides the number of ping packets sent per measurement.
+	Count *int `yaml:"count"`
+
+	// Size overrides the number of bytes of data in each ping packet.
+	Size *int `yaml:"size"`
+
+	// TimeoutMs overrides the number of milliseconds before a ping attempt will timeout.
+	TimeoutMs *int `yaml:"timeout_ms"`
+
+	// IntervalMs overrides the interval in milliseconds at which the host is pinged.
+	IntervalMs *int `yaml:"interval_ms"`
+
+	// Priority orders the host in fallover mode, higher priority hosts are preferred.
+	Priority *int `yaml:"priority"`
+
+	// Alias is a human friendly name for the host recorded with its metrics.
+	Alias *string `yaml:"alias"`
+}
+
+// UnmarshalYAML allows a target to be specified as either a plain host string or a mapping with
+// overrides.
+func (t *TargetConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&t.Host)
+	}
+
+	type rawTargetConfig TargetConfig
+	return value.Decode((*rawTargetConfig)(t))
+}
+
+// loadConfig reads and validates the YAML configuration file at path. Unknown keys are logged as
+// warnings rather than treated as errors.
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file \"%s\": %w", path, err)
+	}
+
+	// Decode strictly first only to find unknown keys
+	strictDecoder := yaml.NewDecoder(bytes.NewReader(data))
+	strictDecoder.KnownFields(true)
+
+	var typeErr *yaml.TypeError
+	if err := strictDecoder.Decode(&Config{}); errors.As(err, &typeErr) {
+		for _, msg := range typeErr.Errors {
+			if strings.Contains(msg, "not found in type") {
+				slog.Warn("unknown key in config file", "path", path, "error", msg)
+			}
+		}
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file \"%s\": %w", path, err)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file \"%s\": %w", path, err)
+	}
+
+	return &config, nil
+}
+
+// validate checks that the configuration values are consistent.
+func (c *Config) validate() error {
+	if c.Fallover != nil && *c.Fallover && c.All != nil && *c.All {
+		return errors.New("fallover and all cannot both be enabled")
+	}
+
+	if c.PingCount != nil && *c.PingCount < 1 {
+		return fmt.Errorf("ping_count must be at least 1, got %d", *c.PingCount)
+	}
+
+	if c.MaxConcurrency != nil && *c.MaxConcurrency < 1 {
+		return fmt.Errorf("max_concurrency must be at least 1, got %d", *c.MaxConcurrency)
+	}
+
+	for i, target := range c.Targets {
+		if len(target.Host) == 0 {
+			return fmt.Errorf("targets[%d]: host must not be empty", i)
+		}
+
+		if target.Count != nil && *target.Count < 1 {
+			return fmt.Errorf("targets[%d] (%s): count must be at least 1", i, target.Host)
+		}
+
+		if target.Size != nil && (*target.Size < MIN_PING_SIZE || *target.Size > MAX_PING_SIZE) {
+			return fmt.Errorf(
+				"targets[%d] (%s): size must be between %d and %d",
+				i,
+				target.Host,
+				MIN_PING_SIZE,
+				MAX_PING_SIZE,
+			)
+		}
+
+		if target.TimeoutMs != nil && *target.TimeoutMs <= 0 {
+			return fmt.Errorf("targets[%d] (%s): timeout_ms must be positive", i, target.Host)
+		}
+
+		if target.IntervalMs != nil && *target.IntervalMs <= 0 {
+			return fmt.Errorf("targets[%d] (%s): interval_ms must be positive", i, target.Host)
+		}
+
+		if target.Alias != nil && len(*target.Alias) == 0 {
+			return fmt.Errorf("targets[%d] (%s): alias must not be empty", i, target.Host)
+		}
+	}
+
+	return nil
+}
+
+// Hosts returns the host of each target in order.
+func (c *Config) Hosts() []string {
+	hosts := make([]string, 0, len(c.Targets))
+	for _, target := range c.Targets {
+		hosts = append(hosts, target.Host)
+	}
+
+	return hosts
+}
+
+// configSettings are the options which may be set by the config file.
+type configSettings struct {
+	// targetHosts are the target hosts provided by -t or the config file.
+	targetHosts []string
+
+	// overrides are the per target host options from the config file, keyed by targetKey of the
+	// host without its alias or interval.
+	overrides map[string]TargetConfig
+
+	metricsHost    string
+	pingMs         int
+	pingCount      int
+	fallover       bool
+	all            bool
+	maxConcurrency int
+}
+
+// applyConfig returns settings with the values of config applied, config may be nil. Options in
+// setFlags were explicitly provided on the command line or by environment variables and take
+// precedence over the config file.
+func applyConfig(
+	settings configSettings,
+	config *Config,
+	setFlags map[string]bool,
+) (configSettings, error) {
+	settings.targetHosts = slices.Clone(settings.targetHosts)
+	settings.overrides = map[string]TargetConfig{}
+	falloverSet := setFlags["f"]
+
+	if config != nil {
+		if !setFlags["t"] && len(config.Targets) > 0 {
+			settings.targetHosts = config.Hosts()
+		}
+
+		for _, target := range config.Targets {
+			// A host may be written with an alias or interval, ie. "10.0.0.5=core-router"
+			parsed, err := parseTarget(target.Host, settings.pingMs)
+			if err != nil {
+				return configSettings{}, err
+			}
+
+			settings.overrides[targetKey(parsed.Host)] = target
+		}
+
+		if !setFlags["m"] && len(config.MetricsHost) > 0 {
+			settings.metricsHost = config.MetricsHost
+		}
+
+		if !setFlags["p"] && config.PingIntervalMs != nil {
+			settings.pingMs = *config.PingIntervalMs
+		}
+
+		if !setFlags["c"] && config.PingCount != nil {
+			settings.pingCount = *config.PingCount
+		}
+
+		if !setFlags["f"] && config.Fallover != nil {
+			settings.fallover = *config.Fallover
+			falloverSet = true
+		}
+
+		if !setFlags["a"] && config.All != nil {
+			settings.all = *config.All
+		}
+
+		if !setFlags["max-concurrency"] && config.MaxConcurrency != nil {
+			settings.maxConcurrency = *config.MaxConcurrency
+		}
+	}
+
+	// -f is enabled by default, so only asking for -a implies disabling -f
+	if settings.all && !falloverSet {
+		settings.fallover = false
+	}
+
+	if settings.fallover && settings.all {
+		return configSettings{}, errors.New("options -f (fallover) and -a (all) cannot both be provided")
+	}
+
+	return settings, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// DEFAULT_DEGRADED_INTERVAL_MS is the default number of milliseconds between measurements while in
+// degraded mode. 1 minute.
+const DEFAULT_DEGRADED_INTERVAL_MS int = 60000
+
+// degradedTracker slows measurements down once every target host has failed for a number of
+// consecutive cycles, ie. when the uplink is lost, until any target host recovers.
+type degradedTracker struct {
+	// after is the number of consecutive cycles in which all target hosts failed before degraded
+	// mode is entered, 0 disables degraded mode.
+	after int
+
+	// interval is the time between measurements of each key while degraded.
+	interval time.Duration
+
+	lock sync.Mutex
+
+	// degraded indicates degraded mode was entered.
+	degraded bool
+
+	// lastMeasured is when each key was last measured while degraded.
+	lastMeasured map[string]time.Time
+
+	gauge prom.Gauge
+}
+
+// newDegradedTracker creates a degradedTracker which is not degraded, recording the state to gauge.
+func newDegradedTracker(after int, intervalMs int, gauge prom.Gauge) *degradedTracker {
+	return &degradedTracker{
+		after:        after,
+		interval:     time.Duration(intervalMs) * time.Millisecond,
+		lastMeasured: map[string]time.Time{},
+		gauge:        gauge,
+	}
+}
+
+// update records that all target hosts failed in the last failedCycles consecutive cycles. Degraded
+// mode is entered once failedCycles reaches the threshold and left when it is 0.
+func (t *degradedTracker) update(failedCycles int) {
+	if t.after <= 0 {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	switch {
+	case !t.degraded && failedCycles >= t.after:
+		t.degraded = true
+		slog.Info(
+			"all target hosts are failing, entering degraded mode",
+			"consecutive_failures", failedCycles,
+			"interval_ms", t.interval.Milliseconds(),
+		)
+	case t.degraded && failedCycles == 0:
+		t.degraded = false
+		clear(t.lastMeasured)
+		slog.Info("a target host recovered, leaving degraded mode")
+	default:
+		return
+	}
+
+	if t.degraded {
+		t.gauge.Set(1)
+	} else {
+		t.gauge.Set(0)
+	}
+}
+
+// skip indicates if the current measurement of key should be skipped as it was measured less than
+// the degraded interval ago while degraded.
+func (t *degradedTracker) skip(key string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.degraded {
+		return false
+	}
+
+	now := time.Now()
+	if last, ok := t.lastMeasured[key]; ok && now.Sub(last) < t.interval {
+		return true
+	}
+
+	t.lastMeasured[key] = now
+
+	return false
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// DNS_MEASUREMENT is the type of DNS query measurements.
+const DNS_MEASUREMENT string = "dns"
+
+// DNS_QUERY_TIMEOUT_MS is the number of milliseconds before a DNS query will timeout. 5 seconds.
+const DNS_QUERY_TIMEOUT_MS int = 5000
+
+// DNS_DEFAULT_PORT is the port used for a resolver which does not specify one.
+const DNS_DEFAULT_PORT string = "53"
+
+// DNS_QUERY_TYPES are the supported DNS query types.
+var DNS_QUERY_TYPES = []string{"A", "AAAA", "CNAME", "MX", "NS", "TXT"}
+
+// dnsTarget is a DNS query sent to a specific resolver.
+type dnsTarget struct {
+	// resolver is the host:port of the DNS server to query.
+	resolver string
+
+	// record is the DNS name to look up.
+	record string
+
+	// qtype is the query type, one of DNS_QUERY_TYPES.
+	qtype string
+}
+
+// parseDNSTargets parses "resolver:record:qtype" values into dnsTargets. The resolver may include a
+// port, ie. "1.1.1.1:53:example.com:A", otherwise DNS_DEFAULT_PORT is used.
+func parseDNSTargets(values []string) ([]dnsTarget, error) {
+	targets := make([]dnsTarget, 0, len(values))
+	for _, value := range values {
+		// Split from the right as the resolver may contain a port
+		lastSep := strings.LastIndex(value, ":")
+		if lastSep < 0 {
+			return nil, fmt.Errorf(
+				"invalid DNS target \"%s\": must be in the form resolver:record:qtype",
+				value,
+			)
+		}
+		qtype := strings.ToUpper(value[lastSep+1:])
+
+		recordSep := strings.LastIndex(value[:lastSep], ":")
+		if recordSep < 0 {
+			return nil, fmt.Errorf(
+				"invalid DNS target \"%s\": must be in the form resolver:record:qtype",
+				value,
+			)
+		}
+		record := value[recordSep+1 : lastSep]
+		resolver := value[:recordSep]
+
+		if len(resolver) == 0 || len(record) == 0 {
+			return nil, fmt.Errorf(
+				"invalid DNS target \"%s\": resolver and record must not be empty",
+				value,
+			)
+		}
+
+		if _, _, err := net.SplitHostPort(resolver); err != nil {
+			resolver = net.JoinHostPort(strings.Trim(resolver, "[]"), DNS_DEFAULT_PORT)
+		}
+
+		if !slices.Contains(DNS_QUERY_TYPES, qtype) {
+			return nil, fmt.Errorf(
+				"invalid DNS target \"%s\": unsupported query type \"%s\", must be one of: %s",
+				value,
+				qtype,
+				strings.Join(DNS_QUERY_TYPES, ", "),
+			)
+		}
+
+		targets = append(targets, dnsTarget{
+			resolver: resolver,
+			record:   record,
+			qtype:    qtype,
+		})
+	}
+
+	return targets, nil
+}
+
+// dnsMeasurer periodically sends DNS queries to resolvers and records the query duration.
+type dnsMeasurer struct {
+	// targets are the queries to send.
+	targets []dnsTarget
+
+	// intervalMs is the number of milliseconds to wait between measurements.
+	intervalMs int
+
+	// heartbeat is beat after every measurement.
+	heartbeat *heartbeat
+
+	// intervalJitter randomizes the time between measurements.
+	intervalJitter intervalJitter
+
+	// compensateDrift starts measurements on a fixed cadence rather than waiting the interval after
+	// each one finishes.
+	compensateDrift bool
+
+	// failureLog collapses repeated warnings about persistently failing targets.
+	failureLog *failureLogger
+
+	duration *prom.HistogramVec
+	failures *prom.CounterVec
+}
+
+// newDNSMeasurer creates a dnsMeasurer and registers its Prometheus metrics.
+func newDNSMeasurer(targets []dnsTarget, intervalMs int, metrics metricsOptions) *dnsMeasurer {
+	m := &dnsMeasurer{
+		targets:    targets,
+		intervalMs: intervalMs,
+		failureLog: newFailureLogger(),
+		duration: prom.NewHistogramVec(
+			prom.HistogramOpts{
+				Namespace: metrics.namespace,
+				Name:      "dns_query_duration_ms",
+				Help:      "Time for a resolver to answer a DNS query in milliseconds",
+				Buckets: []float64{
+					0, 1, 2, 5, 10, 20, 50, 100, 200, 500,
+					1000, 2000, 5000,
+				},
+			},
+			[]string{"resolver", "record", "qtype"},
+		),
+		failures: prom.NewCounterVec(
+			prom.CounterOpts{
+				Namespace: metrics.namespace,
+				Name:      "dns_query_failures_total",
+				Help:      "Failures of resolvers to answer DNS queries",
+			},
+			[]string{"resolver", "record", "qtype"},
+		),
+	}
+
+	prom.MustRegister(m.duration)
+	prom.MustRegister(m.failures)
+
+	return m
+}
+
+// run performs measurements until ctx is done, waiting for the interval between each.
+func (m *dnsMeasurer) run(ctx context.Context) {
+	timer := newIntervalTimer(
+		DNS_MEASUREMENT,
+		time.Duration(m.intervalMs)*time.Millisecond,
+		m.intervalJitter,
+		m.compensateDrift,
+	)
+	defer timer.stop()
+
+	for {
+		start := time.Now()
+		m.measure(ctx)
+		m.heartbeat.finished(start)
+
+		if !timer.wait(ctx) {
+			return
+		}
+	}
+}
+
+// measureAll sends every query once.
+func (m *dnsMeasurer) measureAll(ctx context.Context) []measurement {
+	return m.measure(ctx)
+}
+
+// measure sends each query once.
+func (m *dnsMeasurer) measure(ctx context.Context) []measurement {
+	results := []measurement{}
+	for _, target := range m.targets {
+		host := target.resolver + " " + target.record + " " + target.qtype
+		labels := prom.Labels{
+			"resolver": target.resolver,
+			"record":   target.record,
+			"qtype":    target.qtype,
+		}
+
+		queryCtx, cancel := context.WithTimeout(
+			ctx,
+			time.Duration(DNS_QUERY_TIMEOUT_MS)*time.Millisecond,
+		)
+		start := time.Now()
+		err := queryDNS(queryCtx, target)
+		elapsed := time.Since(start)
+		cancel()
+
+		if ctx.Err() != nil {
+			// Shutting down, the measurement was interrupted so don't record it
+			return results
+		}
+		if err != nil {
+			m.failureLog.failed(
+				host,
+				"failed to query dns",
+				"resolver", target.resolver,
+				"record", target.record,
+				"qtype", target.qtype,
+				"error", err,
+			)
+			m.failures.With(labels).Inc()
+			results = append(results, failedMeasurement(DNS_MEASUREMENT, host, err))
+			continue
+		}
+
+		elapsedMs := durationMs(elapsed)
+
+		m.duration.With(labels).Observe(elapsedMs)
+		m.failureLog.succeeded(
+			host,
+			"resolver", target.resolver,
+			"record", target.record,
+			"qtype", target.qtype,
+		)
+		slog.Debug(
+			"dns query measured",
+			"resolver", target.resolver,
+			"record", target.record,
+			"qtype", target.qtype,
+			"duration_ms", elapsedMs,
+		)
+		results = append(results, successfulMeasurement(DNS_MEASUREMENT, host, elapsedMs))
+	}
+
+	return results
+}
+
+// queryDNS sends the DNS query for target to its resolver. The record is queried as a fully
+// qualified name so neither /etc/hosts nor the search list answer in place of the resolver.
+func queryDNS(ctx context.Context, target dnsTarget) error {
+	record := target.record
+	if !strings.HasSuffix(record, ".") {
+		record += "."
+	}
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, target.resolver)
+		},
+	}
+
+	var err error
+	switch target.qtype {
+	case "A":
+		_, err = resolver.LookupIP(ctx, "ip4", record)
+	case "AAAA":
+		_, err = resolver.LookupIP(ctx, "ip6", record)
+	case "CNAME":
+		_, err = resolver.LookupCNAME(ctx, record)
+	case "MX":
+		_, err = resolver.LookupMX(ctx, record)
+	case "NS":
+		_, err = resolver.LookupNS(ctx, record)
+	case "TXT":
+		_, err = resolver.LookupTXT(ctx, record)
+	default:
+		err = fmt.Errorf("unsupported query type \"%s\"", target.qtype)
+	}
+
+	return err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ENV_VAR_PREFIX starts the name of every environment variable which sets a flag.
+const ENV_VAR_PREFIX string = "NET_TEST_"
+
+// FLAG_ENV_VARS are the environment variables of flags whose names are too short to be descriptive.
+// Other flags use their name in upper case with dashes replaced by underscores.
+var FLAG_ENV_VARS = map[string]string{
+	"t": ENV_VAR_PREFIX + "TARGETS",
+	"T": ENV_VAR_PREFIX + "PRIMARY_TARGET",
+	"m": ENV_VAR_PREFIX + "METRICS_HOST",
+	"f": ENV_VAR_PREFIX + "FALLOVER",
+	"a": ENV_VAR_PREFIX + "ALL",
+	"c": ENV_VAR_PREFIX + "PING_COUNT",
+	"p": ENV_VAR_PREFIX + "PING_INTERVAL_MS",
+	"v": ENV_VAR_PREFIX + "VERBOSE",
+}
+
+// FLAGS_WITHOUT_ENV_VARS are flags which cannot be set by an environment variable, as they only
+// make sense when explicitly asked for.
+var FLAGS_WITHOUT_ENV_VARS = []string{"version"}
+
+// envVarName returns the environment variable which sets the flag named name.
+func envVarName(name string) string {
+	if envVar, ok := FLAG_ENV_VARS[name]; ok {
+		return envVar
+	}
+
+	return ENV_VAR_PREFIX + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// isListFlag indicates if f accepts multiple comma separated values, ie.
+// NET_TEST_TARGETS="1.1.1.1,8.8.8.8".
+func isListFlag(f *flag.Flag) bool {
+	value, ok := f.Value.(*StrArrFlag)
+	return ok && value.split
+}
+
+// documentEnvVars appends the environment variable of each flag in flags to its usage. Must be
+// called after all flags are defined.
+func documentEnvVars(flags *flag.FlagSet) {
+	flags.VisitAll(func(f *flag.Flag) {
+		if slices.Contains(FLAGS_WITHOUT_ENV_VARS, f.Name) {
+			return
+		}
+
+		if isListFlag(f) {
+			f.Usage += fmt.Sprintf(" [env %s, comma separated]", envVarName(f.Name))
+			return
+		}
+
+		f.Usage += fmt.Sprintf(" [env %s]", envVarName(f.Name))
+	})
+}
+
+// setFlagsFromEnv sets each flag in flags which was not provided on the command line from its
+// environment variable, if set. Must be called after flags are parsed.
+func setFlagsFromEnv(flags *flag.FlagSet) error {
+	provided := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		provided[f.Name] = true
+	})
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || provided[f.Name] || slices.Contains(FLAGS_WITHOUT_ENV_VARS, f.Name) {
+			return
+		}
+
+		envVar := envVarName(f.Name)
+		value, ok := os.LookupEnv(envVar)
+		if !ok {
+			return
+		}
+
+		// List flags split comma separated values themselves
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value \"%s\" for environment variable %s: %w", value, envVar, setErr)
+		}
+	})
+
+	return err
+}
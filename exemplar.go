@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// EXEMPLAR_TRACE_ID_LABEL is the label name of the trace ID in an exemplar.
+const EXEMPLAR_TRACE_ID_LABEL string = "trace_id"
+
+// exemplarLabels returns the labels of an exemplar identifying the current measurement, the trace
+// ID of the span in ctx if there is one, otherwise a generated ID in the same format.
+func exemplarLabels(ctx context.Context) prom.Labels {
+	traceID := trace.SpanContextFromContext(ctx).TraceID()
+	if !traceID.IsValid() {
+		_, _ = rand.Read(traceID[:])
+	}
+
+	return prom.Labels{
+		EXEMPLAR_TRACE_ID_LABEL: traceID.String(),
+	}
+}
+
+// observeWithExemplar records value with observer, attaching an exemplar from ctx if observer
+// supports exemplars. Summaries do not.
+func observeWithExemplar(ctx context.Context, observer prom.Observer, value float64) {
+	if exemplarObserver, ok := observer.(prom.ExemplarObserver); ok {
+		exemplarObserver.ObserveWithExemplar(value, exemplarLabels(ctx))
+		return
+	}
+
+	observer.Observe(value)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// FAILURE_LOG_SUMMARY_EVERY is the number of consecutive identical failures of a target after which
+// a summary is logged, the failures in between are only logged at the debug level.
+const FAILURE_LOG_SUMMARY_EVERY int = 10
+
+// targetFailures is the logging state of a single failing target.
+type targetFailures struct {
+	// count is the number of consecutive failures.
+	count int
+
+	// msg is the message of the last failure logged.
+	msg string
+}
+
+// failureLogger collapses repeated identical warnings about a persistently failing target, so a
+// target which is down for hours does not log every interval. The first failure, a failure with a
+// different message, and every FAILURE_LOG_SUMMARY_EVERY consecutive failures are logged.
+type failureLogger struct {
+	lock    sync.Mutex
+	targets map[string]*targetFailures
+}
+
+// newFailureLogger creates a failureLogger with no failing targets.
+func newFailureLogger() *failureLogger {
+	return &failureLogger{
+		targets: map[string]*targetFailures{},
+	}
+}
+
+// failed logs a failure of target with msg and the key value pairs in args, unless it repeats the
+// last failure of target.
+func (l *failureLogger) failed(target string, msg string, args ...any) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	state, ok := l.targets[target]
+	if !ok {
+		state = &targetFailures{}
+		l.targets[target] = state
+	}
+
+	state.count++
+	args = append(args, "consecutive_failures", state.count)
+
+	switch {
+	case state.msg != msg:
+		state.msg = msg
+		slog.Warn(msg, args...)
+	case state.count%FAILURE_LOG_SUMMARY_EVERY == 0:
+		slog.Warn("still failing: "+msg, args...)
+	default:
+		slog.Debug(msg, args...)
+	}
+}
+
+// succeeded logs the recovery of target, with the key value pairs in args, if it was failing.
+func (l *failureLogger) succeeded(target string, args ...any) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	state, ok := l.targets[target]
+	if !ok {
+		return
+	}
+
+	delete(l.targets, target)
+	slog.Info("target recovered", append(args, "consecutive_failures", state.count)...)
+}
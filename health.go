@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// HEALTHZ_INTERVAL_MULTIPLIER is the number of measurement intervals which may pass without a
+// measurement loop iteration before the loop is considered stuck.
+const HEALTHZ_INTERVAL_MULTIPLIER int = 3
+
+// heartbeat records when a measurement loop last completed an iteration.
+type heartbeat struct {
+	// name identifies the measurement loop.
+	name string
+
+	// intervalNanos is the expected time between iterations of the loop, which may change when the
+	// config file is reloaded.
+	intervalNanos atomic.Int64
+
+	// measureNanos is the longest an iteration may take to measure, which is allowed on top of the
+	// intervals so slow iterations, ie. while target hosts time out, aren't considered stuck.
+	measureNanos atomic.Int64
+
+	// lastUnixNano is the time of the last iteration.
+	lastUnixNano atomic.Int64
+
+	// loopDuration records how long each iteration which measured took.
+	loopDuration prom.Observer
+}
+
+// beat records that the measurement loop completed an iteration.
+func (h *heartbeat) beat() {
+	h.lastUnixNano.Store(time.Now().UnixNano())
+}
+
+// finished records that the measurement loop completed an iteration which measured, started at
+// start.
+func (h *heartbeat) finished(start time.Time) {
+	h.loopDuration.Observe(time.Since(start).Seconds())
+	h.beat()
+}
+
+// setInterval changes the expected time between iterations of the measurement loop.
+func (h *heartbeat) setInterval(interval time.Duration) {
+	h.intervalNanos.Store(int64(interval))
+}
+
+// setMeasureDuration changes the longest an iteration of the measurement loop may take to measure.
+func (h *heartbeat) setMeasureDuration(duration time.Duration) {
+	h.measureNanos.Store(int64(duration))
+}
+
+// alive indicates if the measurement loop completed an iteration recently enough at now.
+func (h *heartbeat) alive(now time.Time) bool {
+	maxAge := time.Duration(HEALTHZ_INTERVAL_MULTIPLIER)*time.Duration(h.intervalNanos.Load()) +
+		time.Duration(h.measureNanos.Load())
+	return now.Sub(time.Unix(0, h.lastUnixNano.Load())) <= maxAge
+}
+
+// healthChecker serves the liveness endpoint based on measurement loop heartbeats.
+type healthChecker struct {
+	lock       sync.Mutex
+	heartbeats []*heartbeat
+
+	// loopDurations records how long each measurement loop iteration took, by measurement loop.
+	loopDurations *prom.HistogramVec
+}
+
+// newHealthChecker creates a healthChecker and registers its Prometheus metrics.
+func newHealthChecker(metrics metricsOptions) *healthChecker {
+	c := &healthChecker{
+		loopDurations: prom.NewHistogramVec(
+			prom.HistogramOpts{
+				Namespace: metrics.namespace,
+				Name:      "net_test_loop_duration_seconds",
+				Help:      "Time each iteration of a measurement loop took to measure its targets in seconds, longer than the interval means iterations overlap or drift",
+				Buckets: []float64{
+					0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300,
+				},
+			},
+			[]string{"measurement"},
+		),
+	}
+
+	prom.MustRegister(c.loopDurations)
+
+	return c
+}
+
+// add creates a heartbeat for a measurement loop which is expected to iterate every interval. The
+// loop is considered alive from when the heartbeat is added.
+func (c *healthChecker) add(name string, interval time.Duration) *heartbeat {
+	h := &heartbeat{
+		name: name,
+		loopDuration: c.loopDurations.With(prom.Labels{
+			"measurement": name,
+		}),
+	}
+	h.setInterval(interval)
+	h.beat()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.heartbeats = append(c.heartbeats, h)
+
+	return h
+}
+
+// startAfter considers every measurement loop alive until delay from now, when they start
+// measuring.
+func (c *healthChecker) startAfter(delay time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	start := time.Now().Add(delay).UnixNano()
+	for _, h := range c.heartbeats {
+		h.lastUnixNano.Store(start)
+	}
+}
+
+// ServeHTTP responds 200 if all measurement loops are alive, otherwise 503.
+func (c *healthChecker) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	for _, h := range c.heartbeats {
+		if !h.alive(now) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = fmt.Fprintf(w, "%s measurement has not run recently", h.name)
+			return
+		}
+	}
+
+	_, _ = fmt.Fprint(w, "ok")
+}
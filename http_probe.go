@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http/httpguts"
+	"golang.org/x/net/proxy"
+)
+
+// HTTP_MEASUREMENT is the type of HTTP request measurements.
+const HTTP_MEASUREMENT string = "http"
+
+// DEFAULT_HTTP_TIMEOUT_MS is the default number of milliseconds before an HTTP request will
+// timeout. 10 seconds.
+const DEFAULT_HTTP_TIMEOUT_MS int = 10000
+
+// HTTP_HEADER_SEPARATOR separates the name and value of a -http-header, ie. "Authorization: Bearer
+// token".
+const HTTP_HEADER_SEPARATOR string = ":"
+
+// parseHTTPTargets validates that each value is an absolute http or https URL.
+func parseHTTPTargets(values []string) ([]string, error) {
+	for _, value := range values {
+		u, err := url.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTP target \"%s\": %w", value, err)
+		}
+
+		if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return nil, fmt.Errorf(
+				"invalid HTTP target \"%s\": must be an absolute http:// or https:// URL",
+				value,
+			)
+		}
+	}
+
+	return values, nil
+}
+
+// parseHTTPHeaders parses "Name: value" values into the headers added to HTTP requests. Names
+// must be valid HTTP header field names, whitespace around the name and value is trimmed.
+func parseHTTPHeaders(values []string) (http.Header, error) {
+	headers := http.Header{}
+	for _, value := range values {
+		name, headerValue, ok := strings.Cut(value, HTTP_HEADER_SEPARATOR)
+		name = strings.TrimSpace(name)
+		headerValue = strings.TrimSpace(headerValue)
+		if !ok || !httpguts.ValidHeaderFieldName(name) {
+			return nil, fmt.Errorf(
+				"invalid HTTP header \"%s\": must be in the form Name: value, ie. Authorization: Bearer token",
+				value,
+			)
+		}
+
+		if !httpguts.ValidHeaderFieldValue(headerValue) {
+			return nil, fmt.Errorf("invalid HTTP header \"%s\": value contains invalid characters", value)
+		}
+
+		headers.Add(name, headerValue)
+	}
+
+	return headers, nil
+}
+
+// httpMeasurer periodically performs HTTP GET requests against URLs and records the duration and
+// response code.
+type httpMeasurer struct {
+	// urls are the URLs to request.
+	urls []string
+
+	// intervalMs is the number of milliseconds to wait between measurements.
+	intervalMs int
+
+	// headers are added to every request. A Host header overrides the host requested, rather than
+	// the host of the URL.
+	headers http.Header
+
+	client *http.Client
+
+	// heartbeat is beat after every measurement.
+	heartbeat *heartbeat
+
+	// intervalJitter randomizes the time between measurements.
+	intervalJitter intervalJitter
+
+	// compensateDrift starts measurements on a fixed cadence rather than waiting the interval after
+	// each one finishes.
+	compensateDrift bool
+
+	// failureLog collapses repeated warnings about persistently failing targets.
+	failureLog *failureLogger
+
+	duration     *prom.HistogramVec
+	responseCode *prom.GaugeVec
+	failures     *prom.CounterVec
+}
+
+// newHTTPMeasurer creates an httpMeasurer and registers its Prometheus metrics.
+func newHTTPMeasurer(
+	urls []string,
+	intervalMs int,
+	timeoutMs int,
+	followRedirects bool,
+	headers http.Header,
+	dialer proxy.ContextDialer,
+	metrics metricsOptions,
+) *httpMeasurer {
+	transport, _ := http.DefaultTransport.(*http.Transport)
+	transport = transport.Clone()
+	transport.DialContext = dialer.DialContext
+	if _, direct := dialer.(*net.Dialer); !direct {
+		// With -proxy requests go through the SOCKS5 proxy only, not also HTTP_PROXY or HTTPS_PROXY
+		transport.Proxy = nil
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(timeoutMs) * time.Millisecond,
+	}
+	if !followRedirects {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	m := &httpMeasurer{
+		urls:       urls,
+		intervalMs: intervalMs,
+		headers:    headers,
+		client:     client,
+		failureLog: newFailureLogger(),
+		duration: prom.NewHistogramVec(
+			prom.HistogramOpts{
+				Namespace: metrics.namespace,
+				Name:      "http_request_duration_ms",
+				Help:      "Time to complete an HTTP GET request to a URL in milliseconds",
+				Buckets: []float64{
+					0, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100,
+					200, 400, 600, 800, 1000,
+					5000, 10000,
+					20000, 30000,
+				},
+			},
+			[]string{"url"},
+		),
+		responseCode: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: metrics.namespace,
+				Name:      "http_response_code",
+				Help:      "HTTP status code of the last response from a URL",
+			},
+			[]string{"url"},
+		),
+		failures: prom.NewCounterVec(
+			prom.CounterOpts{
+				Namespace: metrics.namespace,
+				Name:      "http_request_failures_total",
+				Help:      "Failures to complete HTTP requests to URLs",
+			},
+			[]string{"url"},
+		),
+	}
+
+	prom.MustRegister(m.duration)
+	prom.MustRegister(m.responseCode)
+	prom.MustRegister(m.failures)
+
+	return m
+}
+
+// run performs measurements until ctx is done, waiting for the interval between each.
+func (m *httpMeasurer) run(ctx context.Context) {
+	timer := newIntervalTimer(
+		HTTP_MEASUREMENT,
+		time.Duration(m.intervalMs)*time.Millisecond,
+		m.intervalJitter,
+		m.compensateDrift,
+	)
+	defer timer.stop()
+
+	for {
+		start := time.Now()
+		m.measure(ctx)
+		m.heartbeat.finished(start)
+
+		if !timer.wait(ctx) {
+			return
+		}
+	}
+}
+
+// measureAll requests every URL once.
+func (m *httpMeasurer) measureAll(ctx context.Context) []measurement {
+	return m.measure(ctx)
+}
+
+// measure requests each URL once.
+func (m *httpMeasurer) measure(ctx context.Context) []measurement {
+	results := []measurement{}
+	for _, target := range m.urls {
+		labels := prom.Labels{
+			"url": target,
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			m.failureLog.failed(target, "failed to create http request", "url", target, "error", err)
+			m.failures.With(labels).Inc()
+			results = append(results, failedMeasurement(HTTP_MEASUREMENT, target, err))
+			continue
+		}
+		m.addHeaders(req)
+
+		start := time.Now()
+		resp, err := m.client.Do(req)
+		if ctx.Err() != nil {
+			// Shutting down, the measurement was interrupted so don't record it
+			if resp != nil {
+				_ = resp.Body.Close()
+			}
+			return results
+		}
+		if err != nil {
+			m.failureLog.failed(target, "failed to perform http request", "url", target, "error", err)
+			m.failures.With(labels).Inc()
+			results = append(results, failedMeasurement(HTTP_MEASUREMENT, target, err))
+			continue
+		}
+
+		// Read the whole body so the duration includes the transfer
+		_, err = io.Copy(io.Discard, resp.Body)
+		elapsed := time.Since(start)
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			slog.Warn("failed to close http response body", "url", target, "error", closeErr)
+		}
+		if ctx.Err() != nil {
+			return results
+		}
+		if err != nil {
+			m.failureLog.failed(target, "failed to read http response", "url", target, "error", err)
+			m.failures.With(labels).Inc()
+			results = append(results, failedMeasurement(HTTP_MEASUREMENT, target, err))
+			continue
+		}
+
+		elapsedMs := durationMs(elapsed)
+
+		m.duration.With(labels).Observe(elapsedMs)
+		m.responseCode.With(labels).Set(float64(resp.StatusCode))
+		m.failureLog.succeeded(target, "url", target)
+		slog.Debug(
+			"http request measured",
+			"url", target,
+			"duration_ms", elapsedMs,
+			"status_code", resp.StatusCode,
+		)
+		results = append(results, successfulMeasurement(HTTP_MEASUREMENT, target, elapsedMs))
+	}
+
+	return results
+}
+
+// addHeaders adds the configured headers to req. Go ignores a Host header when sending a request,
+// so it is applied to the host of the request instead.
+func (m *httpMeasurer) addHeaders(req *http.Request) {
+	for name, values := range m.headers {
+		if name == "Host" {
+			req.Host = values[len(values)-1]
+			continue
+		}
+
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+}
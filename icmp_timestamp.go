@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// TIMESTAMP_MEASUREMENT is the type of ICMP timestamp measurements.
+const TIMESTAMP_MEASUREMENT string = "timestamp"
+
+// DEFAULT_TIMESTAMP_INTERVAL_MS is the default number of milliseconds between ICMP timestamp
+// measurements. 10 seconds.
+const DEFAULT_TIMESTAMP_INTERVAL_MS int = 10000
+
+// TIMESTAMP_TIMEOUT_MS is the number of milliseconds to wait for a reply to an ICMP timestamp
+// request. 5 seconds.
+const TIMESTAMP_TIMEOUT_MS int = 5000
+
+// TIMESTAMP_BODY_BYTES is the size of the body of an ICMP timestamp message, the identifier,
+// sequence number, and originate, receive, and transmit timestamps.
+const TIMESTAMP_BODY_BYTES int = 16
+
+// timestampMeasurer periodically sends ICMP timestamp requests to each ping target host and records
+// the time until the reply, a fallback reachability test for devices which filter echo requests.
+type timestampMeasurer struct {
+	// pings provide the target hosts and how they are pinged.
+	pings *pingMeasurer
+
+	// intervalMs is the number of milliseconds to wait between measurements.
+	intervalMs int
+
+	// heartbeat is beat after every measurement.
+	heartbeat *heartbeat
+
+	// intervalJitter randomizes the time between measurements.
+	intervalJitter intervalJitter
+
+	// compensateDrift starts measurements on a fixed cadence rather than waiting the interval after
+	// each one finishes.
+	compensateDrift bool
+
+	// failureLog collapses repeated warnings about persistently failing targets.
+	failureLog *failureLogger
+
+	rtt      *prom.HistogramVec
+	failures *prom.CounterVec
+}
+
+// newTimestampMeasurer creates a timestampMeasurer for the target hosts of pings and registers its
+// Prometheus metrics.
+func newTimestampMeasurer(
+	pings *pingMeasurer,
+	intervalMs int,
+	metrics metricsOptions,
+) *timestampMeasurer {
+	m := &timestampMeasurer{
+		pings:      pings,
+		intervalMs: intervalMs,
+		failureLog: newFailureLogger(),
+		rtt: prom.NewHistogramVec(
+			prom.HistogramOpts{
+				Namespace: metrics.namespace,
+				Name:      "icmp_timestamp_rtt_ms",
+				Help:      "Time until a target host replied to an ICMP timestamp request in milliseconds",
+				Buckets:   pings.buckets,
+			},
+			[]string{"target_host"},
+		),
+		failures: prom.NewCounterVec(
+			prom.CounterOpts{
+				Namespace: metrics.namespace,
+				Name:      "icmp_timestamp_failures_total",
+				Help:      "Failures of target hosts to reply to ICMP timestamp requests",
+			},
+			[]string{"target_host"},
+		),
+	}
+
+	prom.MustRegister(m.rtt)
+	prom.MustRegister(m.failures)
+
+	return m
+}
+
+// run performs measurements until ctx is done, waiting for the interval between each.
+func (m *timestampMeasurer) run(ctx context.Context) {
+	timer := newIntervalTimer(
+		TIMESTAMP_MEASUREMENT,
+		time.Duration(m.intervalMs)*time.Millisecond,
+		m.intervalJitter,
+		m.compensateDrift,
+	)
+	defer timer.stop()
+
+	for {
+		start := time.Now()
+		m.measure(ctx)
+		m.heartbeat.finished(start)
+
+		if !timer.wait(ctx) {
+			return
+		}
+	}
+}
+
+// measureAll sends an ICMP timestamp request to every target host once.
+func (m *timestampMeasurer) measureAll(ctx context.Context) []measurement {
+	return m.measure(ctx)
+}
+
+// measure sends an ICMP timestamp request to each target host once.
+func (m *timestampMeasurer) measure(ctx context.Context) []measurement {
+	results := []measurement{}
+	for _, target := range m.pings.currentTargets() {
+		host := target.Host
+		labels := prom.Labels{
+			"target_host": host,
+		}
+
+		var rtt time.Duration
+		ipAddr, err := m.pings.resolve(ctx, host)
+		if err == nil {
+			rtt, err = m.probe(host, ipAddr)
+		}
+		if ctx.Err() != nil {
+			// Shutting down, the measurement was interrupted so don't record it
+			return results
+		}
+		if err != nil {
+			m.failureLog.failed(
+				host,
+				"failed to measure icmp timestamp",
+				"target_host", host,
+				"error", err,
+			)
+			m.failures.With(labels).Inc()
+			results = append(results, failedMeasurement(TIMESTAMP_MEASUREMENT, host, err))
+			continue
+		}
+
+		rttMs := durationMs(rtt)
+		m.rtt.With(labels).Observe(rttMs)
+		m.failureLog.succeeded(host, "target_host", host)
+		slog.Debug("icmp timestamp measured", "target_host", host, "rtt_ms", rttMs)
+		results = append(results, successfulMeasurement(TIMESTAMP_MEASUREMENT, host, rttMs))
+	}
+
+	return results
+}
+
+// probe sends an ICMP timestamp request to ipAddr of host and returns the time until its reply.
+func (m *timestampMeasurer) probe(host string, ipAddr *net.IPAddr) (time.Duration, error) {
+	if ipAddr.IP.To4() == nil {
+		return 0, errors.New("no IPv4 address, ICMP timestamp requests are IPv4 only")
+	}
+
+	// Raw sockets are required, unprivileged ping sockets only send echo requests
+	address := "0.0.0.0"
+	if len(m.pings.source) > 0 {
+		address = m.pings.source
+	}
+
+	var conn *icmp.PacketConn
+	err := inNamespace(m.pings.namespaceOf(host), func() error {
+		var err error
+		conn, err = icmp.ListenPacket("ip4:icmp", address)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to open icmp socket: %w", err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	// Sent from the same interface as pings
+	ifIndex, err := interfaceIndex(m.pings.interfaceName)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := conn.IPv4PacketConn().SetTTL(m.pings.ttl); err != nil {
+		return 0, fmt.Errorf("failed to set ttl: %w", err)
+	}
+
+	// Requests from concurrent measurements are told apart by their identifier
+	id := uint16(rand.N(1 << 16)) //nolint:mnd,gosec
+
+	now := time.Now().UTC()
+	request := icmp.Message{
+		Type: ipv4.ICMPTypeTimestamp,
+		Body: &icmp.RawBody{
+			Data: timestampBody(id, now),
+		},
+	}
+	data, err := request.Marshal(nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create icmp timestamp request: %w", err)
+	}
+
+	start := time.Now()
+	deadline := start.Add(time.Duration(TIMESTAMP_TIMEOUT_MS) * time.Millisecond)
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return 0, fmt.Errorf("failed to set icmp socket deadline: %w", err)
+	}
+
+	if err := writeICMP(conn, data, ipAddr, false, ifIndex); err != nil {
+		return 0, fmt.Errorf("failed to send icmp timestamp request: %w", err)
+	}
+
+	response := make([]byte, UDP_MAX_RESPONSE_BYTES)
+	for {
+		n, peer, err := conn.ReadFrom(response)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return 0, errors.New("no icmp timestamp reply received")
+			}
+
+			return 0, fmt.Errorf("failed to receive icmp response: %w", err)
+		}
+		rtt := time.Since(start)
+
+		// Raw sockets receive every ICMP message, so skip those which aren't the reply
+		peerAddr, ok := peer.(*net.IPAddr)
+		if !ok || !peerAddr.IP.Equal(ipAddr.IP) {
+			continue
+		}
+
+		message, err := icmp.ParseMessage(ICMPV4_PROTOCOL, response[:n])
+		if err != nil || message.Type != ipv4.ICMPTypeTimestampReply {
+			continue
+		}
+
+		body, ok := message.Body.(*icmp.RawBody)
+		if !ok || len(body.Data) < TIMESTAMP_BODY_BYTES ||
+			binary.BigEndian.Uint16(body.Data[0:2]) != id {
+			continue
+		}
+
+		return rtt, nil
+	}
+}
+
+// timestampBody creates the body of an ICMP timestamp request with identifier id, originated at
+// now. Timestamps are milliseconds since midnight UTC.
+func timestampBody(id uint16, now time.Time) []byte {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	body := make([]byte, TIMESTAMP_BODY_BYTES)
+	binary.BigEndian.PutUint16(body[0:2], id)
+	binary.BigEndian.PutUint16(body[2:4], 1)
+	binary.BigEndian.PutUint32(body[4:8], uint32(now.Sub(midnight).Milliseconds())) //nolint:gosec
+
+	return body
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// intervalTimer waits between iterations of a measurement loop.
+type intervalTimer struct {
+	// name identifies the measurement loop in warnings.
+	name string
+
+	// interval is the time between iterations.
+	interval time.Duration
+
+	// jitter randomizes the time between iterations.
+	jitter intervalJitter
+
+	// ticker fires on a fixed cadence if drift is compensated for, otherwise nil and the interval
+	// is slept after each iteration.
+	ticker *time.Ticker
+}
+
+// newIntervalTimer creates an intervalTimer for the measurement loop name. If compensateDrift is
+// set iterations start every interval regardless of how long each takes, otherwise the interval is
+// waited after each iteration finishes. The timer must be stopped when no longer used.
+func newIntervalTimer(
+	name string,
+	interval time.Duration,
+	jitter intervalJitter,
+	compensateDrift bool,
+) *intervalTimer {
+	t := &intervalTimer{
+		name:     name,
+		interval: interval,
+		jitter:   jitter,
+	}
+
+	if compensateDrift {
+		t.ticker = time.NewTicker(interval)
+	}
+
+	return t
+}
+
+// stop releases the resources of the timer.
+func (t *intervalTimer) stop() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+	}
+}
+
+// wait blocks until the next iteration should start, returning false if ctx is done first.
+func (t *intervalTimer) wait(ctx context.Context) bool {
+	if t.ticker == nil {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(t.jitter.around(t.interval)):
+		}
+
+		return true
+	}
+
+	// A tick which is already waiting fired during the iteration, which overran the interval. It's
+	// skipped so iterations don't run back to back and stay on the cadence.
+	select {
+	case <-t.ticker.C:
+		slog.Warn(
+			"measurement took longer than the interval, skipping the next one",
+			"measurement", t.name,
+			"interval_ms", t.interval.Milliseconds(),
+		)
+	default:
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.ticker.C:
+	}
+
+	// Delayed after the tick rather than resetting the ticker so the cadence stays the interval
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(t.jitter.delay(t.interval)):
+	}
+
+	return true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JITTER_PERCENT_SUFFIX marks a -jitter value as a percentage of the interval, ie. "10%".
+const JITTER_PERCENT_SUFFIX string = "%"
+
+// intervalJitter randomizes the time between measurements so many instances started at once don't
+// measure targets in lockstep.
+type intervalJitter struct {
+	// percent is the largest amount to randomize by as a percentage of the interval.
+	percent float64
+
+	// ms is the largest number of milliseconds to randomize by, used if percent is 0.
+	ms int
+}
+
+// parseJitter parses a number of milliseconds, ie. "500", or a percentage of the interval suffixed
+// with JITTER_PERCENT_SUFFIX, ie. "10%". An empty value disables jitter.
+func parseJitter(value string) (intervalJitter, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return intervalJitter{}, nil
+	}
+
+	if percentValue, ok := strings.CutSuffix(value, JITTER_PERCENT_SUFFIX); ok {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(percentValue), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return intervalJitter{}, fmt.Errorf(
+				"invalid jitter \"%s\": percentage must be between 0%% and 100%%",
+				value,
+			)
+		}
+
+		return intervalJitter{percent: percent}, nil
+	}
+
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms < 0 {
+		return intervalJitter{}, fmt.Errorf(
+			"invalid jitter \"%s\": must be a non-negative number of milliseconds or a percentage, ie. 10%%",
+			value,
+		)
+	}
+
+	return intervalJitter{ms: ms}, nil
+}
+
+// max returns the largest amount interval is randomized by, never more than interval itself.
+func (j intervalJitter) max(interval time.Duration) time.Duration {
+	maxJitter := time.Duration(j.ms) * time.Millisecond
+	if j.percent > 0 {
+		maxJitter = time.Duration(float64(interval) * j.percent / 100) //nolint:mnd
+	}
+
+	return min(maxJitter, interval)
+}
+
+// around returns interval randomly shortened or lengthened by up to the jitter, so on average it
+// is interval.
+func (j intervalJitter) around(interval time.Duration) time.Duration {
+	maxJitter := j.max(interval)
+	if maxJitter <= 0 {
+		return interval
+	}
+
+	return interval - maxJitter + rand.N(2*maxJitter+1) //nolint:mnd
+}
+
+// delay returns a random duration up to the jitter, to wait after a tick of a ticker with interval
+// so measurements stay on the ticker's cadence on average.
+func (j intervalJitter) delay(interval time.Duration) time.Duration {
+	maxJitter := j.max(interval)
+	if maxJitter <= 0 {
+		return 0
+	}
+
+	return rand.N(maxJitter + 1)
+}
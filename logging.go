@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// LOG_FORMAT_TEXT logs human readable key=value lines.
+const LOG_FORMAT_TEXT string = "text"
+
+// LOG_FORMAT_JSON logs one JSON object per line.
+const LOG_FORMAT_JSON string = "json"
+
+// LOG_FILE_STDOUT is the -log-file value which writes logs to stdout.
+const LOG_FILE_STDOUT string = "-"
+
+// LOG_DIR_PERMISSIONS are the permissions of directories created to hold the log file.
+const LOG_DIR_PERMISSIONS os.FileMode = 0o750
+
+// LOG_FILE_PERMISSIONS are the permissions of a created log file.
+const LOG_FILE_PERMISSIONS os.FileMode = 0o640
+
+// LOG_LEVELS are the supported log levels, from most to least verbose.
+var LOG_LEVELS = []string{"debug", "info", "warn", "error"}
+
+// parseLogLevel parses one of LOG_LEVELS.
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if !slices.Contains(LOG_LEVELS, strings.ToLower(value)) || level.UnmarshalText([]byte(value)) != nil {
+		return 0, fmt.Errorf(
+			"unknown log level \"%s\", must be one of: %s",
+			value,
+			strings.Join(LOG_LEVELS, ", "),
+		)
+	}
+
+	return level, nil
+}
+
+// openLogFile opens the destination of logs. An empty path is stderr and LOG_FILE_STDOUT is stdout,
+// otherwise the file at path is appended to, creating it and its directory if needed. Appending
+// allows the file to be rotated externally, ie. by logrotate with copytruncate.
+func openLogFile(path string) (io.Writer, error) {
+	switch path {
+	case "":
+		return os.Stderr, nil
+	case LOG_FILE_STDOUT:
+		return os.Stdout, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), LOG_DIR_PERMISSIONS); err != nil {
+		return nil, fmt.Errorf("failed to create directory of log file \"%s\": %w", path, err)
+	}
+
+	file, err := os.OpenFile( //nolint:gosec
+		path,
+		os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+		LOG_FILE_PERMISSIONS,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file \"%s\": %w", path, err)
+	}
+
+	return file, nil
+}
+
+// setupLogging configures the default slog logger to write in format to w, only logging messages
+// at level or above.
+func setupLogging(w io.Writer, format string, level slog.Level) error {
+	logger, err := newLogger(w, format, level)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(logger)
+
+	return nil
+}
+
+// newLogger creates a logger which writes messages at level or above in format to w.
+func newLogger(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			// Log timestamps as RFC3339 rather than the default with nanoseconds
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.String(slog.TimeKey, attr.Value.Time().Format(time.RFC3339))
+			}
+
+			return attr
+		},
+	}
+
+	var handler slog.Handler
+	switch format {
+	case LOG_FORMAT_TEXT:
+		handler = slog.NewTextHandler(w, options)
+	case LOG_FORMAT_JSON:
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf(
+			"unknown log format \"%s\", must be one of: %s, %s",
+			format,
+			LOG_FORMAT_TEXT,
+			LOG_FORMAT_JSON,
+		)
+	}
+
+	return slog.New(handler), nil
+}
+
+// fatal logs msg at the error level and exits with a non-zero status.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
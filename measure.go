@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+)
+
+// measurer periodically measures targets and records the results in Prometheus metrics.
+type measurer interface {
+	// run performs measurements until ctx is done.
+	run(ctx context.Context)
+
+	// measureAll measures every target once.
+	measureAll(ctx context.Context) []measurement
+}
+
+// measurement is the result of measuring a target once.
+type measurement struct {
+	// Type is the kind of measurement, ie. "ping" or "tcp".
+	Type string `json:"type"`
+
+	// Host identifies the target which was measured.
+	Host string `json:"host"`
+
+	// Success indicates the target was measured successfully.
+	Success bool `json:"success"`
+
+	// RttMs is the measured duration in milliseconds if successful, 0 otherwise.
+	RttMs float64 `json:"rtt_ms"`
+
+	// Error describes why the measurement failed if unsuccessful.
+	Error string `json:"error,omitempty"`
+
+	// OtherIPVersion is the IP version of the address measured if it is not in the preferred family
+	// of a dual-stack target host, with -dual-stack, empty otherwise.
+	OtherIPVersion string `json:"other_ip_version,omitempty"`
+}
+
+// failedMeasurement creates the measurement of a target which failed with err.
+func failedMeasurement(measurementType string, host string, err error) measurement {
+	return measurement{
+		Type:  measurementType,
+		Host:  host,
+		Error: err.Error(),
+	}
+}
+
+// successfulMeasurement creates the measurement of a target which took rttMs.
+func successfulMeasurement(measurementType string, host string, rttMs float64) measurement {
+	return measurement{
+		Type:    measurementType,
+		Host:    host,
+		Success: true,
+		RttMs:   rttMs,
+	}
+}
+
+// writeMeasurements writes measurements to w in format and indicates if all were successful. The
+// text format logs each measurement for humans, the JSON format is a single JSON array of every
+// measurement for programs to parse.
+func writeMeasurements(w io.Writer, format string, measurements []measurement) (bool, error) {
+	allSucceeded := true
+	for _, m := range measurements {
+		allSucceeded = allSucceeded && m.Success
+	}
+
+	if format == LOG_FORMAT_JSON {
+		return allSucceeded, json.NewEncoder(w).Encode(measurements)
+	}
+
+	logger, err := newLogger(w, format, slog.LevelInfo)
+	if err != nil {
+		return false, err
+	}
+
+	logMeasurements(logger, measurements)
+
+	return allSucceeded, nil
+}
+
+// logMeasurements logs each measurement to logger.
+func logMeasurements(logger *slog.Logger, measurements []measurement) {
+	for _, m := range measurements {
+		attrs := []any{"type", m.Type, "host", m.Host}
+		if len(m.OtherIPVersion) > 0 {
+			attrs = append(attrs, "other_ip_version", m.OtherIPVersion)
+		}
+
+		if m.Success {
+			logger.Info("measurement succeeded", append(attrs, "rtt_ms", m.RttMs)...)
+			continue
+		}
+
+		logger.Error("measurement failed", append(attrs, "error", m.Error)...)
+	}
+}
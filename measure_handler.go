@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MEASURE_PATH is the path on which a POST triggers an immediate measurement of every target.
+const MEASURE_PATH string = "/measure"
+
+// MEASURE_DEBOUNCE is the shortest time between measurements triggered on MEASURE_PATH, so it
+// can't be used to flood target hosts. 5 seconds.
+const MEASURE_DEBOUNCE time.Duration = 5 * time.Second
+
+// MEASURE_RESPONSE_MARGIN is left between the deadline of a triggered measurement and the metrics
+// server write timeout, so the response is written before the connection is closed. 1 second.
+const MEASURE_RESPONSE_MARGIN time.Duration = time.Second
+
+// measureHandler measures every target once when requested, out of band of the measurement
+// loops, recording the same metrics.
+type measureHandler struct {
+	// ctx stops triggered measurements when shutting down, rather than when the client goes away,
+	// so the metrics are still recorded.
+	ctx context.Context
+
+	measurers []measurer
+
+	// timeout bounds each triggered measurement, measurements which don't complete in time are left
+	// out of the response. 0 for no timeout.
+	timeout time.Duration
+
+	// lock guards last which is read and updated by concurrent requests.
+	lock sync.Mutex
+
+	// last is when the previous triggered measurement started.
+	last time.Time
+}
+
+// newMeasureHandler creates a measureHandler which measures with measurers until ctx is done. Each
+// measurement must complete before writeTimeout, the write timeout of the metrics server, if it is
+// not 0.
+func newMeasureHandler(
+	ctx context.Context,
+	measurers []measurer,
+	writeTimeout time.Duration,
+) *measureHandler {
+	timeout := writeTimeout
+	if writeTimeout > 0 {
+		timeout = max(writeTimeout-MEASURE_RESPONSE_MARGIN, writeTimeout/2) //nolint:mnd
+	}
+
+	return &measureHandler{
+		ctx:       ctx,
+		measurers: measurers,
+		timeout:   timeout,
+	}
+}
+
+// allow indicates a measurement may start at now, recording it as the start of the latest
+// measurement if so. Otherwise it returns how long until one may start.
+func (h *measureHandler) allow(now time.Time) (bool, time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if wait := MEASURE_DEBOUNCE - now.Sub(h.last); !h.last.IsZero() && wait > 0 {
+		return false, wait
+	}
+
+	h.last = now
+
+	return true, 0
+}
+
+// ServeHTTP measures every target once and responds with the measurements as a JSON array.
+// Requests within MEASURE_DEBOUNCE of the previous measurement are rejected.
+func (h *measureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	allowed, wait := h.allow(time.Now())
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "measured too recently, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	slog.Info("measuring every target on request", "remote_addr", r.RemoteAddr)
+
+	ctx := h.ctx
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(h.ctx, h.timeout)
+		defer cancel()
+	}
+
+	results := []measurement{}
+	for _, m := range h.measurers {
+		results = append(results, m.measureAll(ctx)...)
+	}
+
+	if ctx.Err() != nil && h.ctx.Err() == nil {
+		slog.Warn(
+			"triggered measurement did not complete before the server write timeout, responding with the completed measurements",
+			"timeout", h.timeout,
+		)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(results)
+}
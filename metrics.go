@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// METRIC_NAMESPACE_PATTERN matches a valid metric namespace, the same characters allowed at the
+// start of a Prometheus metric name.
+var METRIC_NAMESPACE_PATTERN = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// METRIC_LABEL_NAME_PATTERN matches a valid Prometheus label name.
+var METRIC_LABEL_NAME_PATTERN = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// METRIC_LABEL_NAMES are the label names of the metrics, including those Prometheus adds to
+// histograms and summaries, which a constant label cannot use.
+var METRIC_LABEL_NAMES = []string{
+	"target_host", "alias", "ip", "ip_version", "size", "ttl", "dscp", "reason", "phase",
+	"port", "url", "code", "resolver", "record", "qtype",
+	"hop", "hop_ip", "netns",
+	"version", "revision", "build_date", "go_version",
+	"le", "quantile",
+}
+
+// CONST_LABEL_SEPARATOR separates the name and value of a -label, ie. "region=us-east".
+const CONST_LABEL_SEPARATOR string = "="
+
+// metricsOptions configure the Prometheus metrics created by every measurer.
+type metricsOptions struct {
+	// namespace is prefixed to the name of every metric, followed by an underscore, if not empty.
+	namespace string
+
+	// constLabels are added to every metric.
+	constLabels prom.Labels
+
+	// minimal only records the metrics of measurements, without the Go runtime, process, and
+	// metrics endpoint metrics.
+	minimal bool
+}
+
+// validate checks the options produce valid metric names.
+func (o metricsOptions) validate() error {
+	if len(o.namespace) > 0 && !METRIC_NAMESPACE_PATTERN.MatchString(o.namespace) {
+		return fmt.Errorf(
+			"invalid namespace \"%s\": must start with a letter, underscore, or colon, followed by letters, digits, underscores, or colons",
+			o.namespace,
+		)
+	}
+
+	return nil
+}
+
+// useRegistry replaces the default registry with one which adds the constant labels to every
+// metric registered with it, including the Go runtime and process metrics the default registry
+// starts with unless minimal. It must be called before any metric is registered.
+func (o metricsOptions) useRegistry() {
+	if len(o.constLabels) == 0 && !o.minimal {
+		return
+	}
+
+	// A collector can't be registered again with different labels once unregistered, so the
+	// default registry is replaced rather than relabeled
+	registry := prom.NewRegistry()
+	labeled := prom.WrapRegistererWith(o.constLabels, registry)
+	if !o.minimal {
+		labeled.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+	}
+
+	prom.DefaultRegisterer = labeled
+	prom.DefaultGatherer = registry
+}
+
+// parseConstLabels parses "name=value" values into labels. Names must be valid Prometheus label
+// names which are not reserved or in METRIC_LABEL_NAMES.
+func parseConstLabels(values []string) (prom.Labels, error) {
+	labels := prom.Labels{}
+	for _, value := range values {
+		name, labelValue, ok := strings.Cut(value, CONST_LABEL_SEPARATOR)
+		if !ok {
+			return nil, fmt.Errorf("invalid label \"%s\": must be in the form name=value", value)
+		}
+
+		if !METRIC_LABEL_NAME_PATTERN.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf(
+				"invalid label \"%s\": name must start with a letter or underscore, followed by letters, digits, or underscores, and not start with __",
+				value,
+			)
+		}
+
+		if slices.Contains(METRIC_LABEL_NAMES, name) {
+			return nil, fmt.Errorf(
+				"invalid label \"%s\": name \"%s\" is already used by metrics",
+				value,
+				name,
+			)
+		}
+
+		if !utf8.ValidString(labelValue) {
+			return nil, fmt.Errorf("invalid label \"%s\": value must be valid UTF-8", value)
+		}
+
+		if _, ok := labels[name]; ok {
+			return nil, fmt.Errorf("invalid label \"%s\": name provided more than once", value)
+		}
+
+		labels[name] = labelValue
+	}
+
+	return labels, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DEFAULT_METRICS_PATH is the default path on which Prometheus metrics are served.
+const DEFAULT_METRICS_PATH string = "/metrics"
+
+// HEALTHZ_PATH is the path on which the liveness endpoint is served.
+const HEALTHZ_PATH string = "/healthz"
+
+// ROOT_PAGE is the HTML page served at the root path for humans, formatted with the metrics and
+// liveness paths.
+const ROOT_PAGE string = `<!DOCTYPE html>
+<html>
+<head><title>Net Test</title></head>
+<body>
+<h1>Net Test</h1>
+<p><a href="%[1]s">Metrics</a></p>
+<p><a href="%[2]s">Health</a></p>
+</body>
+</html>
+`
+
+// validateMetricsPath checks path can be used to serve Prometheus metrics.
+func validateMetricsPath(path string) error {
+	if len(path) == 0 || path[0] != '/' {
+		return fmt.Errorf("invalid metrics path \"%s\": must start with /", path)
+	}
+
+	if path == "/" || path == HEALTHZ_PATH || path == RTT_HISTORY_PATH || path == MEASURE_PATH {
+		return fmt.Errorf("invalid metrics path \"%s\": already used by another endpoint", path)
+	}
+
+	return nil
+}
+
+// rootHandler serves ROOT_PAGE linking to metricsPath.
+func rootHandler(metricsPath string) http.Handler {
+	page := fmt.Sprintf(ROOT_PAGE, html.EscapeString(metricsPath), HEALTHZ_PATH)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = fmt.Fprint(w, page)
+	})
+}
+
+// newMetricsHandler creates the handler which serves the metrics gathered by gatherer. Unless
+// minimal it is instrumented with metrics about its own requests, registered in a dedicated
+// registry so they describe scrapes only and are not pushed or written to a textfile with the
+// measurements. With openMetrics the OpenMetrics format is served to scrapers which request it.
+func newMetricsHandler(gatherer prom.Gatherer, minimal bool, openMetrics bool) http.Handler {
+	options := promhttp.HandlerOpts{
+		EnableOpenMetrics: openMetrics,
+	}
+
+	if minimal {
+		return promhttp.HandlerFor(gatherer, options)
+	}
+
+	registry := prom.NewRegistry()
+
+	duration := prom.NewHistogramVec(
+		prom.HistogramOpts{
+			Name:    "promhttp_metric_handler_request_duration_seconds",
+			Help:    "Time to serve a scrape of the metrics endpoint in seconds",
+			Buckets: prom.DefBuckets,
+		},
+		[]string{"code"},
+	)
+	registry.MustRegister(duration)
+
+	handler := promhttp.HandlerFor(prom.Gatherers{gatherer, registry}, options)
+
+	// Registers promhttp_metric_handler_requests_total and
+	// promhttp_metric_handler_requests_in_flight
+	return promhttp.InstrumentHandlerDuration(
+		duration,
+		promhttp.InstrumentMetricHandler(registry, handler),
+	)
+}
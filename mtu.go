@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	probing "github.com/prometheus-community/pro-bing"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// MTU_MEASUREMENT is the type of path MTU discovery measurements.
+const MTU_MEASUREMENT string = "mtu"
+
+// DEFAULT_MTU_INTERVAL_MS is the default number of milliseconds between path MTU discoveries. 5
+// minutes.
+const DEFAULT_MTU_INTERVAL_MS int = 300000
+
+// MTU_PROBE_TIMEOUT_MS is the number of milliseconds to wait for the reply to each probe of a path
+// MTU discovery. 2 seconds.
+const MTU_PROBE_TIMEOUT_MS int = 2000
+
+// MAX_PATH_MTU is the largest path MTU which is discovered in bytes, a jumbo frame.
+const MAX_PATH_MTU int = 9000
+
+// ICMP_HEADER_BYTES is the size of an ICMP echo header in bytes.
+const ICMP_HEADER_BYTES int = 8
+
+// IPV4_HEADER_BYTES is the size of an IPv4 header without options in bytes.
+const IPV4_HEADER_BYTES int = 20
+
+// IPV6_HEADER_BYTES is the size of an IPv6 header without extension headers in bytes.
+const IPV6_HEADER_BYTES int = 40
+
+// mtuMeasurer periodically discovers the path MTU to each ping target host by searching for the
+// largest ping which gets a reply with the don't fragment bit set.
+type mtuMeasurer struct {
+	// pings provide the target hosts and how they are pinged.
+	pings *pingMeasurer
+
+	// intervalMs is the number of milliseconds to wait between discoveries.
+	intervalMs int
+
+	// heartbeat is beat after every measurement.
+	heartbeat *heartbeat
+
+	// intervalJitter randomizes the time between measurements.
+	intervalJitter intervalJitter
+
+	// compensateDrift starts measurements on a fixed cadence rather than waiting the interval after
+	// each one finishes.
+	compensateDrift bool
+
+	// failureLog collapses repeated warnings about persistently failing targets.
+	failureLog *failureLogger
+
+	pathMTU *prom.GaugeVec
+}
+
+// newMTUMeasurer creates an mtuMeasurer for the target hosts of pings and registers its Prometheus
+// metrics.
+func newMTUMeasurer(pings *pingMeasurer, intervalMs int, metrics metricsOptions) *mtuMeasurer {
+	m := &mtuMeasurer{
+		pings:      pings,
+		intervalMs: intervalMs,
+		failureLog: newFailureLogger(),
+		pathMTU: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: metrics.namespace,
+				Name:      "path_mtu_bytes",
+				Help:      "Largest IP packet which reached a target host without being fragmented in the last discovery in bytes",
+			},
+			[]string{"target_host"},
+		),
+	}
+
+	prom.MustRegister(m.pathMTU)
+
+	return m
+}
+
+// run performs measurements until ctx is done, waiting for the interval between each.
+func (m *mtuMeasurer) run(ctx context.Context) {
+	timer := newIntervalTimer(
+		MTU_MEASUREMENT,
+		time.Duration(m.intervalMs)*time.Millisecond,
+		m.intervalJitter,
+		m.compensateDrift,
+	)
+	defer timer.stop()
+
+	for {
+		start := time.Now()
+		m.measure(ctx)
+		m.heartbeat.finished(start)
+
+		if !timer.wait(ctx) {
+			return
+		}
+	}
+}
+
+// measureAll discovers the path MTU to every target host once.
+func (m *mtuMeasurer) measureAll(ctx context.Context) []measurement {
+	return m.measure(ctx)
+}
+
+// measure discovers the path MTU to each target host once.
+func (m *mtuMeasurer) measure(ctx context.Context) []measurement {
+	results := []measurement{}
+	for _, target := range m.pings.currentTargets() {
+		host := target.Host
+
+		ipAddr, err := m.pings.resolve(ctx, host)
+		if err == nil {
+			var mtu int
+			mtu, err = m.discover(ctx, host, ipAddr)
+			if err == nil {
+				m.pathMTU.With(prom.Labels{
+					"target_host": host,
+				}).Set(float64(mtu))
+				m.failureLog.succeeded(host, "target_host", host)
+				slog.Debug("path mtu discovered", "target_host", host, "path_mtu_bytes", mtu)
+				results = append(results, successfulMeasurement(MTU_MEASUREMENT, host, 0))
+				continue
+			}
+		}
+
+		if ctx.Err() != nil {
+			// Shutting down, the measurement was interrupted so don't record it
+			return results
+		}
+
+		m.failureLog.failed(host, "failed to discover path mtu", "target_host", host, "error", err)
+		results = append(results, failedMeasurement(MTU_MEASUREMENT, host, err))
+	}
+
+	return results
+}
+
+// discover binary searches for the largest ping payload to host at ipAddr which gets a reply with
+// the don't fragment bit set, and returns the size of the IP packet which carried it.
+func (m *mtuMeasurer) discover(ctx context.Context, host string, ipAddr *net.IPAddr) (int, error) {
+	headerBytes := ICMP_HEADER_BYTES + IPV4_HEADER_BYTES
+	if ipVersion(ipAddr.IP) == IP_VERSION_6 {
+		headerBytes = ICMP_HEADER_BYTES + IPV6_HEADER_BYTES
+	}
+
+	// The smallest payload must get a reply for the search to mean anything
+	low := MIN_PING_SIZE
+	ok, err := m.probe(ctx, host, ipAddr, low)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, errors.New("no reply to the smallest ping")
+	}
+
+	high := MAX_PATH_MTU - headerBytes
+	for low < high {
+		mid := (low + high + 1) / 2 //nolint:mnd
+
+		ok, err := m.probe(ctx, host, ipAddr, mid)
+		if err != nil {
+			return 0, err
+		}
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		if ok {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+
+	return low + headerBytes, nil
+}
+
+// probe indicates if a ping with size bytes of payload and the don't fragment bit set gets a reply
+// from host at ipAddr. A ping which cannot be sent as it is larger than the local interface MTU is
+// not an error, only being unable to set the don't fragment bit is.
+func (m *mtuMeasurer) probe(
+	ctx context.Context,
+	host string,
+	ipAddr *net.IPAddr,
+	size int,
+) (bool, error) {
+	pinger := m.pings.newPinger(host, ipAddr)
+	pinger.Count = 1
+	pinger.Size = size
+	pinger.Timeout = time.Duration(MTU_PROBE_TIMEOUT_MS) * time.Millisecond
+	pinger.SetDoNotFragment(true)
+
+	err := m.pings.runPinger(ctx, host, pinger)
+	if errors.Is(err, probing.ErrDFNotSupported) {
+		return false, fmt.Errorf("path mtu discovery is not supported on this platform: %w", err)
+	}
+	if err != nil {
+		slog.Debug("path mtu probe failed", "target_host", host, "size", size, "error", err)
+		return false, nil
+	}
+
+	return pinger.Statistics().PacketsRecv > 0, nil
+}
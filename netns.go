@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+
+	"github.com/vishvananda/netns"
+)
+
+// NETNS_SEPARATOR separates a network namespace from the target host measured inside it, ie.
+// "blue=1.1.1.1".
+const NETNS_SEPARATOR string = "="
+
+// parseNamespaces parses "namespace=host" values into the namespace of each target host, keyed by
+// targetKey. A target host may only be measured in a single namespace.
+func parseNamespaces(values []string) (map[string]string, error) {
+	namespaces := map[string]string{}
+	for _, value := range values {
+		namespace, host, ok := strings.Cut(value, NETNS_SEPARATOR)
+		if !ok || len(namespace) == 0 || len(host) == 0 {
+			return nil, fmt.Errorf(
+				"invalid network namespace \"%s\": must be in the form namespace%shost",
+				value,
+				NETNS_SEPARATOR,
+			)
+		}
+
+		key := targetKey(host)
+		if previous, ok := namespaces[key]; ok && previous != namespace {
+			return nil, fmt.Errorf(
+				"target host \"%s\" cannot be measured in both network namespaces \"%s\" and \"%s\"",
+				host,
+				previous,
+				namespace,
+			)
+		}
+
+		namespaces[key] = namespace
+	}
+
+	return namespaces, nil
+}
+
+// checkNamespace returns an error if the named network namespace cannot be entered.
+func checkNamespace(name string) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("network namespaces are only supported on Linux, not %s", runtime.GOOS)
+	}
+
+	handle, err := netns.GetFromName(name)
+	if err != nil {
+		return fmt.Errorf("failed to open network namespace \"%s\": %w", name, err)
+	}
+
+	return handle.Close()
+}
+
+// inNamespace calls fn inside the named network namespace, or directly if name is empty. Sockets
+// created by fn stay in the namespace after it returns.
+func inNamespace(name string, fn func() error) error {
+	if len(name) == 0 {
+		return fn()
+	}
+
+	// The namespace belongs to the OS thread, so fn runs on a locked thread of its own goroutine.
+	// If the thread can't be switched back it is never unlocked, so it exits with the goroutine.
+	done := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		origin, err := netns.Get()
+		if err != nil {
+			runtime.UnlockOSThread()
+			done <- fmt.Errorf("failed to open current network namespace: %w", err)
+			return
+		}
+		defer origin.Close() //nolint:errcheck
+
+		target, err := netns.GetFromName(name)
+		if err != nil {
+			runtime.UnlockOSThread()
+			done <- fmt.Errorf("failed to open network namespace \"%s\": %w", name, err)
+			return
+		}
+		defer target.Close() //nolint:errcheck
+
+		if err := netns.Set(target); err != nil {
+			runtime.UnlockOSThread()
+			done <- fmt.Errorf("failed to enter network namespace \"%s\": %w", name, err)
+			return
+		}
+
+		err = fn()
+
+		if restoreErr := netns.Set(origin); restoreErr != nil {
+			slog.Warn(
+				"failed to leave network namespace, discarding thread",
+				"netns", name,
+				"error", restoreErr,
+			)
+		} else {
+			runtime.UnlockOSThread()
+		}
+
+		done <- err
+	}()
+
+	return <-done
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// OTLP_METRICS_PATH is the path metrics are exported to when -otlp-endpoint has no path.
+const OTLP_METRICS_PATH string = "/v1/metrics"
+
+// OTLP_SCOPE_NAME identifies the instrumentation scope of exported OpenTelemetry metrics.
+const OTLP_SCOPE_NAME string = "github.com/esacteksab/net-test"
+
+// OTLP_SERVICE_NAME is the service.name resource attribute of exported OpenTelemetry metrics.
+const OTLP_SERVICE_NAME string = "net-test"
+
+// parseOTLPEndpoint parses an http or https OTLP endpoint URL, OTLP_METRICS_PATH is used if the URL
+// has no path.
+func parseOTLPEndpoint(value string) (string, error) {
+	endpoint, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid OTLP endpoint \"%s\": %w", value, err)
+	}
+
+	if (endpoint.Scheme != "http" && endpoint.Scheme != "https") || len(endpoint.Host) == 0 {
+		return "", fmt.Errorf(
+			"invalid OTLP endpoint \"%s\": must be an http or https URL, ie. http://localhost:4318",
+			value,
+		)
+	}
+
+	if len(endpoint.Path) == 0 || endpoint.Path == "/" {
+		endpoint.Path = OTLP_METRICS_PATH
+	}
+
+	return endpoint.String(), nil
+}
+
+// otlpExporter periodically exports OpenTelemetry metrics to an OTLP endpoint over HTTP.
+type otlpExporter struct {
+	provider *sdkmetric.MeterProvider
+	meter    metric.Meter
+}
+
+// newOTLPExporter creates an otlpExporter which exports to endpoint every intervalMs.
+func newOTLPExporter(ctx context.Context, endpoint string, intervalMs int) (*otlpExporter, error) {
+	exporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(
+			attribute.String("service.name", OTLP_SERVICE_NAME),
+			attribute.String("service.version", Version),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP resource: %w", err)
+	}
+
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(res),
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(
+			exporter,
+			sdkmetric.WithInterval(time.Duration(intervalMs)*time.Millisecond),
+		)),
+	)
+
+	return &otlpExporter{
+		provider: provider,
+		meter:    provider.Meter(OTLP_SCOPE_NAME),
+	}, nil
+}
+
+// shutdown exports any metrics which have not been exported yet and stops exporting.
+func (e *otlpExporter) shutdown(ctx context.Context) error {
+	return e.provider.Shutdown(ctx)
+}
+
+// otlpName returns the OpenTelemetry instrument name of name, prefixed by the namespace in metrics.
+func otlpName(metrics metricsOptions, name string) string {
+	if len(metrics.namespace) == 0 {
+		return name
+	}
+
+	return metrics.namespace + "." + name
+}
+
+// otlpAttributes converts Prometheus labels to OpenTelemetry attributes.
+func otlpAttributes(labels prom.Labels) []attribute.KeyValue {
+	attributes := make([]attribute.KeyValue, 0, len(labels))
+	for name, value := range labels {
+		attributes = append(attributes, attribute.String(name, value))
+	}
+
+	return attributes
+}
+
+// pingInstruments record ping measurements as OpenTelemetry metrics, mirroring the ping_rtt_ms
+// and ping_failures_total Prometheus metrics.
+type pingInstruments struct {
+	rtt      metric.Float64Histogram
+	failures metric.Int64Counter
+}
+
+// newPingInstruments creates the ping instruments, rtt uses the same bucket boundaries as the
+// ping_rtt_ms histogram.
+func (e *otlpExporter) newPingInstruments(
+	metrics metricsOptions,
+	buckets []float64,
+) (*pingInstruments, error) {
+	rtt, err := e.meter.Float64Histogram(
+		otlpName(metrics, "ping.rtt"),
+		metric.WithDescription("Round trip time for a target host in milliseconds"),
+		metric.WithUnit("ms"),
+		metric.WithExplicitBucketBoundaries(buckets...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ping.rtt instrument: %w", err)
+	}
+
+	failures, err := e.meter.Int64Counter(
+		otlpName(metrics, "ping.failures"),
+		metric.WithDescription("Failures in pings for target hosts"),
+		metric.WithUnit("{failure}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ping.failures instrument: %w", err)
+	}
+
+	return &pingInstruments{
+		rtt:      rtt,
+		failures: failures,
+	}, nil
+}
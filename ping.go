@@ -0,0 +1,1636 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	probing "github.com/prometheus-community/pro-bing"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// DNS_RESOLVE_TIMEOUT_MS is the number of milliseconds before resolving a target host will timeout.
+// 5 seconds.
+const DNS_RESOLVE_TIMEOUT_MS int = 5000
+
+// DEFAULT_PING_RTT_BUCKETS are the ping_rtt_ms histogram buckets used when none are configured.
+var DEFAULT_PING_RTT_BUCKETS = []float64{
+	0, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100,
+	200, 400, 600, 800, 1000,
+	5000, 10000,
+	20000, 30000,
+}
+
+// parseBuckets parses a comma separated list of strictly increasing histogram bucket upper bounds.
+// If value is empty DEFAULT_PING_RTT_BUCKETS are returned.
+func parseBuckets(value string) ([]float64, error) {
+	if len(strings.TrimSpace(value)) == 0 {
+		return DEFAULT_PING_RTT_BUCKETS, nil
+	}
+
+	parts := strings.Split(value, ",")
+	buckets := make([]float64, 0, len(parts))
+	for _, part := range parts {
+		bucket, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket \"%s\": %w", part, err)
+		}
+
+		if len(buckets) > 0 && bucket <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf(
+				"buckets must be strictly increasing, %v is not greater than %v",
+				bucket,
+				buckets[len(buckets)-1],
+			)
+		}
+
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, nil
+}
+
+// METRIC_TYPE_HISTOGRAM records ping_rtt_ms as a histogram with buckets.
+const METRIC_TYPE_HISTOGRAM string = "histogram"
+
+// METRIC_TYPE_SUMMARY records ping_rtt_ms as a summary with quantile objectives.
+const METRIC_TYPE_SUMMARY string = "summary"
+
+// DEFAULT_PING_RTT_OBJECTIVES are the ping_rtt_ms summary quantiles used when none are configured.
+const DEFAULT_PING_RTT_OBJECTIVES string = "0.5,0.9,0.99"
+
+// parseObjectives parses a comma separated list of summary quantiles between 0 and 1. Each quantile
+// is allowed an error of a tenth of its distance from 1, ie. 0.9 is tracked to within 0.01.
+func parseObjectives(value string) (map[float64]float64, error) {
+	objectives := map[float64]float64{}
+	for part := range strings.SplitSeq(value, ",") {
+		quantile, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid objective \"%s\": %w", part, err)
+		}
+
+		if quantile <= 0 || quantile >= 1 {
+			return nil, fmt.Errorf("invalid objective \"%s\": must be between 0 and 1", part)
+		}
+
+		objectives[quantile] = (1 - quantile) / 10 //nolint:mnd
+	}
+
+	return objectives, nil
+}
+
+// PING_MEASUREMENT is the type of ping measurements.
+const PING_MEASUREMENT string = "ping"
+
+// FAILURE_REASON_TIMEOUT is the reason of a ping which timed out before it could complete,
+// including one which received no replies before its timeout.
+const FAILURE_REASON_TIMEOUT string = "timeout"
+
+// FAILURE_REASON_RESOLVE is the reason of a ping to a target host whose DNS name did not resolve.
+const FAILURE_REASON_RESOLVE string = "resolve"
+
+// FAILURE_REASON_PERMISSION is the reason of a ping which was not permitted to open a socket or
+// send, ie. without the privileges for raw ICMP sockets or blocked by a local firewall.
+const FAILURE_REASON_PERMISSION string = "permission"
+
+// FAILURE_REASON_NETWORK_UNREACHABLE is the reason of a ping which had no route to the target
+// host.
+const FAILURE_REASON_NETWORK_UNREACHABLE string = "network_unreachable"
+
+// FAILURE_REASON_NO_PACKETS is the reason of a ping which was sent but stopped before its timeout
+// without receiving any replies.
+const FAILURE_REASON_NO_PACKETS string = "no_packets"
+
+// FAILURE_REASON_BLOCKED is the reason of a ping to a target host which resolved to a private
+// address while -deny-private is set.
+const FAILURE_REASON_BLOCKED string = "blocked"
+
+// FAILURE_REASON_SLOW is the reason of a ping which succeeded but whose average round trip time
+// was longer than -max-rtt-ms.
+const FAILURE_REASON_SLOW string = "slow"
+
+// FAILURE_REASON_OTHER is the reason of a ping which failed with any other error.
+const FAILURE_REASON_OTHER string = "other"
+
+// DEFAULT_PING_MAX_CONCURRENCY is the default maximum number of pings run at the same time.
+const DEFAULT_PING_MAX_CONCURRENCY int = 10
+
+// IP_VERSION_4 is the ip_version label value for IPv4 addresses.
+const IP_VERSION_4 string = "4"
+
+// IP_VERSION_6 is the ip_version label value for IPv6 addresses.
+const IP_VERSION_6 string = "6"
+
+// DEFAULT_PING_SIZE is the default number of bytes of data in each ping packet, the minimum
+// pro-bing allows as it stores a timestamp and tracking UUID in the data.
+const DEFAULT_PING_SIZE int = 24
+
+// MIN_PING_SIZE is the minimum number of bytes of data in each ping packet.
+const MIN_PING_SIZE int = DEFAULT_PING_SIZE
+
+// MAX_PING_SIZE is the maximum number of bytes of data in each ping packet, the largest IPv4
+// packet less the IPv4 and ICMP headers.
+const MAX_PING_SIZE int = 65507
+
+// DEFAULT_PING_PACKET_INTERVAL is the default time between sending each ping packet of a
+// measurement, the pro-bing default.
+const DEFAULT_PING_PACKET_INTERVAL time.Duration = time.Second
+
+// DEFAULT_PING_TTL is the default IP time to live, or IPv6 hop limit, of each ping packet.
+const DEFAULT_PING_TTL int = 64
+
+// MIN_PING_TTL is the minimum IP time to live of each ping packet.
+const MIN_PING_TTL int = 1
+
+// MAX_PING_TTL is the maximum IP time to live of each ping packet.
+const MAX_PING_TTL int = 255
+
+// MAX_PING_DSCP is the maximum DSCP value of each ping packet, six bits.
+const MAX_PING_DSCP int = 63
+
+// DSCP_SHIFT is the number of ECN bits below the DSCP in the IPv4 type of service, or IPv6 traffic
+// class, field of each ping packet.
+const DSCP_SHIFT int = 2
+
+// DEFAULT_EWMA_ALPHA is the default smoothing factor of ping_rtt_ewma_ms, the weight of each new
+// round trip time.
+const DEFAULT_EWMA_ALPHA float64 = 0.3
+
+// PING_RETRY_DELAY_MS is the number of milliseconds to wait before retrying a ping which errored.
+const PING_RETRY_DELAY_MS int = 500
+
+// errBlockedAddress is returned when a target host resolves to a private address while private
+// addresses are denied.
+var errBlockedAddress = errors.New("resolved to a private address")
+
+// pingOptions configure how a pingMeasurer pings target hosts.
+type pingOptions struct {
+	// targets are the target hosts to ping, in order.
+	targets []Target
+
+	// overrides are per target host options which take precedence over the global values, keyed by
+	// targetKey.
+	overrides map[string]TargetConfig
+
+	// count is the number of ping packets sent per measurement.
+	count int
+
+	// packetInterval is the time between sending each ping packet of a measurement.
+	packetInterval time.Duration
+
+	// timeoutMs is the number of milliseconds before a ping attempt will timeout.
+	timeoutMs int
+
+	// size is the number of bytes of data in each ping packet.
+	size int
+
+	// ttl is the IP time to live, or IPv6 hop limit, of each ping packet.
+	ttl int
+
+	// dscp is the Differentiated Services Code Point marked on each ping packet, 0 for best effort.
+	dscp int
+
+	// maxRttMs is the average round trip time in milliseconds above which a successful ping is also
+	// counted as a slow failure, 0 disables.
+	maxRttMs int
+
+	// ewmaAlpha is the smoothing factor of the exponentially weighted moving average round trip
+	// time, between 0 and 1 where higher follows new round trip times more closely.
+	ewmaAlpha float64
+
+	// retries is the number of times a ping which errors is retried before it is recorded as a
+	// failure.
+	retries int
+
+	// warmup sends a discarded ping to each target host before its first recorded measurement, so
+	// ARP or neighbor resolution doesn't skew the first round trip time.
+	warmup bool
+
+	// intervalMs is the number of milliseconds to wait between measurements in fallover mode. When
+	// not in fallover mode each target is measured at its own interval.
+	intervalMs int
+
+	// fallover indicates only the first successfully measured host should be measured.
+	fallover bool
+
+	// primaryFailThreshold is the number of consecutive failures of the first target in fallover
+	// mode before following targets are measured.
+	primaryFailThreshold int
+
+	// degradedAfter is the number of consecutive cycles in which all target hosts failed before
+	// measurements slow down to degradedIntervalMs, 0 disables degraded mode.
+	degradedAfter int
+
+	// degradedIntervalMs is the number of milliseconds between measurements while degraded.
+	degradedIntervalMs int
+
+	// privileged indicates raw ICMP sockets should be used rather than unprivileged UDP sockets.
+	privileged bool
+
+	// ipv6 indicates target hosts should be pinged using their IPv6 address.
+	ipv6 bool
+
+	// dualStack indicates target hosts should also be pinged using their address of the IP family
+	// which is not preferred, if they have one.
+	dualStack bool
+
+	// interfaceName is the name of the network interface pings are sent from, if empty the
+	// operating system chooses.
+	interfaceName string
+
+	// denyPrivate refuses to ping target hosts which resolve to a private, loopback, or link-local
+	// address.
+	denyPrivate bool
+
+	// namespaces are the network namespaces target hosts are measured in, keyed by targetKey.
+	// Target hosts which are not present are measured in the current namespace.
+	namespaces map[string]string
+
+	// source is the local IP address pings are sent from, if empty the operating system chooses.
+	source string
+
+	// maxConcurrency is the maximum number of pings run at the same time, including by /measure
+	// and -discover-mtu.
+	maxConcurrency int
+
+	// buckets are the upper bounds of the ping_rtt_ms histogram buckets.
+	buckets []float64
+
+	// metricType is the type of the ping_rtt_ms metric, METRIC_TYPE_HISTOGRAM or
+	// METRIC_TYPE_SUMMARY.
+	metricType string
+
+	// objectives are the quantiles and their allowed errors of the ping_rtt_ms summary.
+	objectives map[float64]float64
+
+	// exemplars attaches an exemplar with the trace ID of each measurement to ping_rtt_ms
+	// histogram observations.
+	exemplars bool
+
+	// metrics configure the Prometheus metrics which are recorded.
+	metrics metricsOptions
+}
+
+// pingMeasurer periodically pings target hosts and records the round trip time.
+type pingMeasurer struct {
+	pingOptions
+
+	// targetsLock guards pingOptions.targets which may be replaced while running.
+	targetsLock sync.RWMutex
+
+	// targetsChanged receives a value when the targets are replaced.
+	targetsChanged chan struct{}
+
+	// inFlight limits the number of concurrent pings, a value is sent before running a pinger and
+	// received after.
+	inFlight chan struct{}
+
+	resolver *net.Resolver
+
+	// previousRttLock guards previousRttMs and ewmaRttMs which are updated by concurrent
+	// measurements.
+	previousRttLock sync.Mutex
+
+	// previousRttMs is the average round trip time of the last successful measurement of each host,
+	// removed when a measurement fails.
+	previousRttMs map[string]float64
+
+	// ewmaRttMs is the exponentially weighted moving average round trip time of each host, kept
+	// across failures.
+	ewmaRttMs map[string]float64
+
+	// warmedUpLock guards warmedUp which is updated by concurrent measurements.
+	warmedUpLock sync.Mutex
+
+	// warmedUp holds the target hosts which have been sent a warmup ping, so a host whose DNS
+	// answer changes is not warmed up again.
+	warmedUp map[string]bool
+
+	// backoff skips measurements of repeatedly failing hosts.
+	backoff *backoffTracker
+
+	// degraded slows measurements down while all target hosts are failing.
+	degraded *degradedTracker
+
+	// failedCycles is the number of consecutive fallover mode cycles in which no target host was
+	// measured successfully.
+	failedCycles int
+
+	// failureLog collapses repeated warnings about persistently failing targets.
+	failureLog *failureLogger
+
+	// heartbeat is beat after every measurement.
+	heartbeat *heartbeat
+
+	// intervalJitter randomizes the time between measurements.
+	intervalJitter intervalJitter
+
+	// compensateDrift starts measurements on a fixed cadence rather than waiting the interval after
+	// each one finishes.
+	compensateDrift bool
+
+	// history keeps the recent round trip times of each target host, if not nil.
+	history *rttHistory
+
+	// instruments also record measurements as OpenTelemetry metrics, if not nil.
+	instruments *pingInstruments
+
+	rtt          prom.ObserverVec
+	failures     *prom.CounterVec
+	packetLoss   *prom.GaugeVec
+	rttMin       *prom.GaugeVec
+	rttMax       *prom.GaugeVec
+	rttStdDev    *prom.GaugeVec
+	jitter       *prom.GaugeVec
+	rttEwma      *prom.GaugeVec
+	backoffGauge *prom.GaugeVec
+	consecutive  *prom.GaugeVec
+	activeTarget *prom.GaugeVec
+	lastSuccess  *prom.GaugeVec
+	packetsSent  *prom.CounterVec
+	packetsRecv  *prom.CounterVec
+	outOfOrder   *prom.CounterVec
+	duplicates   *prom.CounterVec
+	dnsResolve   *prom.HistogramVec
+	dnsFailures  *prom.CounterVec
+
+	// targetsTotal is the number of targets, updated when they are replaced.
+	targetsTotal prom.Gauge
+
+	// degradedGauge is 1 while in degraded mode, otherwise 0.
+	degradedGauge prom.Gauge
+
+	// inFlightGauge is the number of pingers holding a slot of inFlight.
+	inFlightGauge prom.Gauge
+}
+
+// newPingMeasurer creates a pingMeasurer and registers its Prometheus metrics.
+func newPingMeasurer(options pingOptions) *pingMeasurer {
+	failureLabels := []string{"target_host", "alias", "ip_version", "reason"}
+	if len(options.namespaces) > 0 {
+		failureLabels = append(failureLabels, "netns")
+	}
+
+	m := &pingMeasurer{
+		pingOptions:    options,
+		targetsChanged: make(chan struct{}, 1),
+		inFlight:       make(chan struct{}, max(options.maxConcurrency, 1)),
+		resolver:       net.DefaultResolver,
+		previousRttMs:  map[string]float64{},
+		ewmaRttMs:      map[string]float64{},
+		warmedUp:       map[string]bool{},
+		backoff:        newBackoffTracker(),
+		failureLog:     newFailureLogger(),
+		failures: prom.NewCounterVec(
+			prom.CounterOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_failures_total",
+				Help:      "Failures in pings for target hosts by reason",
+			},
+			failureLabels,
+		),
+		packetLoss: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_packet_loss_percent",
+				Help:      "Percentage of ping packets sent to a target host which were not received in the last measurement",
+			},
+			[]string{"target_host"},
+		),
+		rttMin: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_rtt_min_ms",
+				Help:      "Minimum round trip time for a target host in the last measurement in milliseconds",
+			},
+			[]string{"target_host"},
+		),
+		rttMax: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_rtt_max_ms",
+				Help:      "Maximum round trip time for a target host in the last measurement in milliseconds",
+			},
+			[]string{"target_host"},
+		),
+		rttStdDev: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_rtt_stddev_ms",
+				Help:      "Standard deviation of round trip times for a target host in the last measurement in milliseconds",
+			},
+			[]string{"target_host"},
+		),
+		jitter: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_jitter_ms",
+				Help:      "Absolute difference between the average round trip times of the last two consecutive successful measurements of a target host in milliseconds",
+			},
+			[]string{"target_host"},
+		),
+		rttEwma: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_rtt_ewma_ms",
+				Help:      "Exponentially weighted moving average of the average round trip times of successful measurements of a target host in milliseconds",
+			},
+			[]string{"target_host"},
+		),
+		backoffGauge: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_backoff_seconds",
+				Help:      "Additional time before a repeatedly failing target host is measured again in seconds, 0 when not backing off",
+			},
+			[]string{"target_host"},
+		),
+		consecutive: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_consecutive_failures",
+				Help:      "Number of consecutive failed measurements of a target host, 0 after a successful measurement",
+			},
+			[]string{"target_host"},
+		),
+		activeTarget: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_active_target",
+				Help:      "1 for the target host successfully measured in the last fallover mode measurement, 0 for the other target hosts",
+			},
+			[]string{"target_host"},
+		),
+		lastSuccess: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_last_success_timestamp_seconds",
+				Help:      "Unix time of the last successful measurement of a target host in seconds",
+			},
+			[]string{"target_host"},
+		),
+		packetsSent: prom.NewCounterVec(
+			prom.CounterOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_packets_sent_total",
+				Help:      "Ping packets sent to target hosts",
+			},
+			[]string{"target_host"},
+		),
+		packetsRecv: prom.NewCounterVec(
+			prom.CounterOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_packets_received_total",
+				Help:      "Ping reply packets received from target hosts",
+			},
+			[]string{"target_host"},
+		),
+		outOfOrder: prom.NewCounterVec(
+			prom.CounterOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_out_of_order_total",
+				Help:      "Ping reply packets received from target hosts after a reply to a later ping packet, with -c greater than 1",
+			},
+			[]string{"target_host"},
+		),
+		duplicates: prom.NewCounterVec(
+			prom.CounterOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_duplicate_total",
+				Help:      "Ping reply packets received from target hosts more than once, with -c greater than 1",
+			},
+			[]string{"target_host"},
+		),
+		targetsTotal: prom.NewGauge(
+			prom.GaugeOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "net_test_targets_total",
+				Help:      "Number of target hosts currently configured to be pinged",
+			},
+		),
+		degradedGauge: prom.NewGauge(
+			prom.GaugeOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "net_test_degraded",
+				Help:      "1 while all target hosts are failing and measurements are slowed down, otherwise 0",
+			},
+		),
+		inFlightGauge: prom.NewGauge(
+			prom.GaugeOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_in_flight",
+				Help:      "Number of pings currently running, at most -max-concurrency",
+			},
+		),
+		dnsResolve: prom.NewHistogramVec(
+			prom.HistogramOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_dns_resolve_ms",
+				Help:      "Time to resolve the IP address of a target host before pinging in milliseconds",
+				Buckets: []float64{
+					0, 1, 2, 5, 10, 20, 50, 100, 200, 500,
+					1000, 2000, 5000,
+				},
+			},
+			[]string{"target_host"},
+		),
+		dnsFailures: prom.NewCounterVec(
+			prom.CounterOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "dns_resolution_failures_total",
+				Help:      "Failures to resolve the IP address of a target host before pinging by reason",
+			},
+			[]string{"target_host", "reason"},
+		),
+	}
+
+	rttLabels := []string{"target_host", "alias", "ip", "ip_version", "size", "ttl", "dscp"}
+	if len(options.namespaces) > 0 {
+		rttLabels = append(rttLabels, "netns")
+	}
+
+	if options.metricType == METRIC_TYPE_SUMMARY {
+		m.rtt = prom.NewSummaryVec(
+			prom.SummaryOpts{
+				Namespace:  options.metrics.namespace,
+				Name:       "ping_rtt_ms",
+				Help:       "Round trip time for a target host in milliseconds",
+				Objectives: options.objectives,
+			},
+			rttLabels,
+		)
+	} else {
+		m.rtt = prom.NewHistogramVec(
+			prom.HistogramOpts{
+				Namespace: options.metrics.namespace,
+				Name:      "ping_rtt_ms",
+				Help:      "Round trip time for a target host in milliseconds",
+				Buckets:   options.buckets,
+			},
+			rttLabels,
+		)
+	}
+
+	prom.MustRegister(m.dnsResolve)
+	prom.MustRegister(m.dnsFailures)
+	prom.MustRegister(m.rtt)
+	prom.MustRegister(m.failures)
+	prom.MustRegister(m.packetLoss)
+	prom.MustRegister(m.packetsSent)
+	prom.MustRegister(m.packetsRecv)
+	prom.MustRegister(m.outOfOrder)
+	prom.MustRegister(m.duplicates)
+	prom.MustRegister(m.rttMin)
+	prom.MustRegister(m.rttMax)
+	prom.MustRegister(m.rttStdDev)
+	prom.MustRegister(m.jitter)
+	prom.MustRegister(m.rttEwma)
+	prom.MustRegister(m.targetsTotal)
+	prom.MustRegister(m.backoffGauge)
+	prom.MustRegister(m.consecutive)
+	prom.MustRegister(m.lastSuccess)
+	prom.MustRegister(m.degradedGauge)
+	prom.MustRegister(m.inFlightGauge)
+
+	// Only meaningful in fallover mode, where a single target host is measured at a time
+	if options.fallover {
+		prom.MustRegister(m.activeTarget)
+	}
+
+	m.targetsTotal.Set(float64(len(options.targets)))
+	m.degraded = newDegradedTracker(
+		options.degradedAfter,
+		options.degradedIntervalMs,
+		m.degradedGauge,
+	)
+
+	return m
+}
+
+// run performs measurements until ctx is done. In fallover mode all targets are measured in order
+// at a shared interval, otherwise each target is measured independently at its own interval.
+func (m *pingMeasurer) run(ctx context.Context) {
+	if m.fallover {
+		timer := newIntervalTimer(
+			PING_MEASUREMENT,
+			time.Duration(m.intervalMs)*time.Millisecond,
+			m.intervalJitter,
+			m.compensateDrift,
+		)
+		defer timer.stop()
+
+		for {
+			if m.degraded.skip(PING_MEASUREMENT) {
+				m.heartbeat.beat()
+				if !timer.wait(ctx) {
+					return
+				}
+				continue
+			}
+
+			start := time.Now()
+			targets := m.currentTargets()
+			results := m.measure(ctx, targets)
+			if ctx.Err() == nil {
+				m.recordActiveTarget(targets, results)
+				m.recordCycle(results)
+			}
+			m.heartbeat.finished(start)
+
+			if !timer.wait(ctx) {
+				return
+			}
+		}
+	}
+
+	// Each target runs until it is removed, a changed target is removed and added again
+	var wg sync.WaitGroup
+	running := map[Target]context.CancelFunc{}
+
+	for {
+		targets := m.currentTargets()
+
+		for target, cancel := range running {
+			if !slices.Contains(targets, target) {
+				cancel()
+				delete(running, target)
+			}
+		}
+
+		for _, target := range targets {
+			if _, ok := running[target]; ok {
+				continue
+			}
+
+			targetCtx, cancel := context.WithCancel(ctx)
+			running[target] = cancel
+			wg.Go(func() {
+				m.runTarget(targetCtx, target)
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-m.targetsChanged:
+		}
+	}
+}
+
+// currentTargets returns the targets which should be measured.
+func (m *pingMeasurer) currentTargets() []Target {
+	m.targetsLock.RLock()
+	defer m.targetsLock.RUnlock()
+
+	return m.targets
+}
+
+// setTargets replaces the targets which are measured, measurements of removed targets are stopped
+// and added targets are started.
+func (m *pingMeasurer) setTargets(targets []Target) {
+	m.targetsLock.Lock()
+	m.targets = targets
+	m.targetsLock.Unlock()
+
+	m.targetsTotal.Set(float64(len(targets)))
+	m.updateHeartbeat()
+
+	// Don't block if a change is already waiting to be picked up
+	select {
+	case m.targetsChanged <- struct{}{}:
+	default:
+	}
+}
+
+// reconfigure replaces the options which may change when the config file is reloaded. It must
+// only be called while the pingMeasurer, and any measurer using it, is not running.
+func (m *pingMeasurer) reconfigure(
+	targets []Target,
+	overrides map[string]TargetConfig,
+	count int,
+	intervalMs int,
+	fallover bool,
+) error {
+	if fallover && !m.fallover {
+		// Registered again if fallover mode was enabled before
+		var registered prom.AlreadyRegisteredError
+		if err := prom.Register(m.activeTarget); err != nil && !errors.As(err, &registered) {
+			return fmt.Errorf("failed to register ping_active_target metric: %w", err)
+		}
+	}
+
+	if !fallover {
+		// Stale once not in fallover mode
+		m.activeTarget.Reset()
+	}
+
+	m.overrides = overrides
+	m.count = count
+	m.intervalMs = intervalMs
+	m.fallover = fallover
+	m.failedCycles = 0
+	m.setTargets(targets)
+
+	return nil
+}
+
+// interval returns the longest time between measurements of any target.
+func (m *pingMeasurer) interval() time.Duration {
+	intervalMs := m.intervalMs
+	if !m.fallover {
+		for _, target := range m.currentTargets() {
+			intervalMs = max(intervalMs, target.IntervalMs)
+		}
+	}
+
+	return time.Duration(intervalMs) * time.Millisecond
+}
+
+// maxMeasureDuration returns the longest an iteration of a ping loop may take to measure, when
+// every target host it measures times out on every attempt.
+func (m *pingMeasurer) maxMeasureDuration() time.Duration {
+	timeoutMs := m.timeoutMs
+	for _, override := range m.overrides {
+		if override.TimeoutMs != nil {
+			timeoutMs = max(timeoutMs, *override.TimeoutMs)
+		}
+	}
+
+	attempts := 1 + m.retries
+	if m.warmup {
+		attempts++
+	}
+	if m.dualStack {
+		attempts *= 2
+	}
+
+	perTarget := time.Duration(attempts*timeoutMs)*time.Millisecond +
+		time.Duration(m.retries*PING_RETRY_DELAY_MS)*time.Millisecond
+
+	// In fallover mode a single loop measures every target host one after another, otherwise each
+	// target host has its own loop and a free -max-concurrency slot is released at least this often
+	if m.fallover {
+		return time.Duration(max(len(m.currentTargets()), 1)) * perTarget
+	}
+
+	return perTarget
+}
+
+// updateHeartbeat sets the expected interval and measurement duration of the heartbeat, if any,
+// after the targets or options change.
+func (m *pingMeasurer) updateHeartbeat() {
+	if m.heartbeat == nil {
+		return
+	}
+
+	m.heartbeat.setInterval(m.interval())
+	m.heartbeat.setMeasureDuration(m.maxMeasureDuration())
+}
+
+// runTarget measures a single target on its own interval until ctx is done.
+func (m *pingMeasurer) runTarget(ctx context.Context, target Target) {
+	// Always on a fixed cadence so targets with different intervals are spaced evenly
+	timer := newIntervalTimer(
+		PING_MEASUREMENT+" "+target.Host,
+		time.Duration(target.IntervalMs)*time.Millisecond,
+		m.intervalJitter,
+		true,
+	)
+	defer timer.stop()
+
+	for {
+		if m.degraded.skip(target.Host) {
+			m.heartbeat.beat()
+			if !timer.wait(ctx) {
+				return
+			}
+			continue
+		}
+
+		// Includes waiting for a free slot, so the duration shows when -max-concurrency is too low
+		start := time.Now()
+
+		// Prometheus metrics are safe to update from multiple goroutines at once
+		m.measure(ctx, []Target{target})
+		m.heartbeat.finished(start)
+
+		// Targets have their own cycles, so all failed for as many cycles as the least failing one
+		failedCycles := -1
+		for _, current := range m.currentTargets() {
+			failures := m.backoff.consecutiveFailures(current.Host)
+			if failedCycles < 0 || failures < failedCycles {
+				failedCycles = failures
+			}
+		}
+		m.degraded.update(max(failedCycles, 0))
+
+		if !timer.wait(ctx) {
+			return
+		}
+	}
+}
+
+// pingTarget is a target host and the pinger which will measure it.
+type pingTarget struct {
+	// host is the target host as configured, which may be a DNS name.
+	host string
+
+	// intervalMs is the number of milliseconds between measurements of the host.
+	intervalMs int
+
+	// otherFamily indicates the pinger pings the address of a dual-stack host in the IP family
+	// which is not preferred. It follows the target of the preferred family of the host.
+	otherFamily bool
+
+	pinger *probing.Pinger
+}
+
+// ipVersion returns "4" or "6" for the IP address family of ip.
+func ipVersion(ip net.IP) string {
+	if ip.To4() != nil {
+		return IP_VERSION_4
+	}
+
+	return IP_VERSION_6
+}
+
+// recordJitter records the jitter between avgRttMs and the previous successful measurement of host.
+// Nothing is recorded for the first measurement after a failure.
+func (m *pingMeasurer) recordJitter(host string, avgRttMs float64) {
+	m.previousRttLock.Lock()
+	defer m.previousRttLock.Unlock()
+
+	if previousRttMs, ok := m.previousRttMs[host]; ok {
+		m.jitter.With(prom.Labels{
+			"target_host": host,
+		}).Set(math.Abs(avgRttMs - previousRttMs))
+	}
+
+	m.previousRttMs[host] = avgRttMs
+}
+
+// recordSuccess resets the failure state of host after a successful measurement.
+func (m *pingMeasurer) recordSuccess(host string) {
+	labels := prom.Labels{
+		"target_host": host,
+	}
+
+	m.backoff.succeeded(host)
+	m.backoffGauge.With(labels).Set(0)
+	m.consecutive.With(labels).Set(0)
+	m.lastSuccess.With(labels).SetToCurrentTime()
+}
+
+// recordFailure updates the failure state of host, which is measured every intervalMs, after a
+// failed measurement.
+func (m *pingMeasurer) recordFailure(host string, intervalMs int) {
+	m.resetJitter(host)
+
+	failures, backoff := m.backoff.failed(host, intervalMs)
+
+	labels := prom.Labels{
+		"target_host": host,
+	}
+	m.backoffGauge.With(labels).Set(backoff.Seconds())
+	m.consecutive.With(labels).Set(float64(failures))
+
+	if backoff > 0 {
+		slog.Debug(
+			"backing off measuring failing host",
+			"target_host", host,
+			"consecutive_failures", failures,
+			"backoff", backoff,
+		)
+	}
+}
+
+// recordEwma updates the moving average round trip time of host with avgRttMs. The first
+// measurement of host starts the average.
+func (m *pingMeasurer) recordEwma(host string, avgRttMs float64) {
+	m.previousRttLock.Lock()
+	defer m.previousRttLock.Unlock()
+
+	ewmaMs := avgRttMs
+	if previousMs, ok := m.ewmaRttMs[host]; ok {
+		ewmaMs = m.ewmaAlpha*avgRttMs + (1-m.ewmaAlpha)*previousMs
+	}
+
+	m.ewmaRttMs[host] = ewmaMs
+	m.rttEwma.With(prom.Labels{
+		"target_host": host,
+	}).Set(ewmaMs)
+}
+
+// resetJitter forgets the previous measurement of host after a failure, so jitter is not calculated
+// across the gap and the last value does not linger.
+func (m *pingMeasurer) resetJitter(host string) {
+	m.previousRttLock.Lock()
+	defer m.previousRttLock.Unlock()
+
+	delete(m.previousRttMs, host)
+	m.jitter.Delete(prom.Labels{
+		"target_host": host,
+	})
+}
+
+// observeRtt records a round trip time of rttMs with labels, and as an OpenTelemetry metric if
+// enabled.
+func (m *pingMeasurer) observeRtt(ctx context.Context, labels prom.Labels, rttMs float64) {
+	m.addAliasLabel(labels)
+	m.addNamespaceLabel(labels)
+	if m.exemplars {
+		observeWithExemplar(ctx, m.rtt.With(labels), rttMs)
+	} else {
+		m.rtt.With(labels).Observe(rttMs)
+	}
+
+	if m.instruments != nil {
+		m.instruments.rtt.Record(ctx, rttMs, metric.WithAttributes(otlpAttributes(labels)...))
+	}
+}
+
+// addAliasLabel adds the alias of the target_host in labels.
+func (m *pingMeasurer) addAliasLabel(labels prom.Labels) {
+	labels["alias"] = m.aliasOf(labels["target_host"])
+}
+
+// aliasOf returns the alias of host, or host if it is not a current target or has no alias.
+func (m *pingMeasurer) aliasOf(host string) string {
+	for _, target := range m.currentTargets() {
+		if target.Host == host {
+			return cmp.Or(target.Alias, host)
+		}
+	}
+
+	return host
+}
+
+// addNamespaceLabel adds the network namespace the target_host in labels is measured in, if
+// measuring in network namespaces.
+func (m *pingMeasurer) addNamespaceLabel(labels prom.Labels) {
+	if len(m.namespaces) > 0 {
+		labels["netns"] = m.namespaceOf(labels["target_host"])
+	}
+}
+
+// namespaceOf returns the network namespace host is measured in, empty for the current namespace.
+func (m *pingMeasurer) namespaceOf(host string) string {
+	return m.namespaces[targetKey(host)]
+}
+
+// runPinger runs pinger inside the network namespace of host once a slot of inFlight is free.
+func (m *pingMeasurer) runPinger(ctx context.Context, host string, pinger *probing.Pinger) error {
+	// Wait for a free slot, so a slow host only delays others once above the concurrency limit
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case m.inFlight <- struct{}{}:
+	}
+	m.inFlightGauge.Inc()
+	defer func() {
+		m.inFlightGauge.Dec()
+		<-m.inFlight
+	}()
+
+	return inNamespace(m.namespaceOf(host), func() error {
+		return pinger.RunWithContext(ctx)
+	})
+}
+
+// countFailure records a failure to ping host using IP version for reason, and as an OpenTelemetry
+// metric if enabled.
+func (m *pingMeasurer) countFailure(
+	ctx context.Context,
+	host string,
+	version string,
+	reason string,
+) {
+	labels := prom.Labels{
+		"target_host": host,
+		"ip_version":  version,
+		"reason":      reason,
+	}
+	m.addAliasLabel(labels)
+	m.addNamespaceLabel(labels)
+	m.failures.With(labels).Inc()
+
+	if m.instruments != nil {
+		m.instruments.failures.Add(ctx, 1, metric.WithAttributes(otlpAttributes(labels)...))
+	}
+}
+
+// recordCycle records if any target was successfully measured in the results of a fallover mode
+// cycle, entering or leaving degraded mode. Only the preferred IP family of a dual-stack target
+// host counts, as the other family does not take part in fallover.
+func (m *pingMeasurer) recordCycle(results []measurement) {
+	m.failedCycles++
+	for _, result := range results {
+		if result.Success && len(result.OtherIPVersion) == 0 {
+			m.failedCycles = 0
+			break
+		}
+	}
+
+	m.degraded.update(m.failedCycles)
+}
+
+// recordActiveTarget records which of targets was successfully measured in results at its
+// preferred IP family, if any.
+func (m *pingMeasurer) recordActiveTarget(targets []Target, results []measurement) {
+	active := ""
+	for _, result := range results {
+		if result.Success && len(result.OtherIPVersion) == 0 {
+			active = result.Host
+			break
+		}
+	}
+
+	// Reset so target hosts removed by a reload are no longer reported
+	m.activeTarget.Reset()
+	for _, target := range targets {
+		value := 0.0
+		if target.Host == active {
+			value = 1
+		}
+
+		m.activeTarget.With(prom.Labels{
+			"target_host": target.Host,
+		}).Set(value)
+	}
+}
+
+// holdPrimary indicates the failing host is the first of targets in fallover mode and has not yet
+// failed primaryFailThreshold consecutive times, so the following targets should not be measured.
+func (m *pingMeasurer) holdPrimary(host string, targets []Target) bool {
+	if !m.fallover || len(targets) == 0 || targets[0].Host != host {
+		return false
+	}
+
+	failures := m.backoff.consecutiveFailures(host)
+	if failures >= m.primaryFailThreshold {
+		return false
+	}
+
+	slog.Info(
+		"primary target host failed, not falling over until threshold is reached",
+		"target_host", host,
+		"consecutive_failures", failures,
+		"threshold", m.primaryFailThreshold,
+	)
+
+	return true
+}
+
+// isLocalAddress indicates if ip is assigned to any local network interface.
+func isLocalAddress(ip net.IP) (bool, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false, fmt.Errorf("failed to list local interface addresses: %w", err)
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// resolve looks up the IP address which will be pinged for host. In IPv6 mode only an IPv6 address
+// is used, otherwise IPv4 is preferred but an IPv6 address is used if the host has no IPv4 address.
+func (m *pingMeasurer) resolve(ctx context.Context, host string) (*net.IPAddr, error) {
+	addrs, err := m.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.preferredAddr(host, addrs)
+}
+
+// lookup resolves every IP address of host.
+func (m *pingMeasurer) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	resolveCtx, cancel := context.WithTimeout(
+		ctx,
+		time.Duration(DNS_RESOLVE_TIMEOUT_MS)*time.Millisecond,
+	)
+	defer cancel()
+
+	start := time.Now()
+	addrs, err := m.resolver.LookupIPAddr(resolveCtx, host)
+	elapsed := time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+
+	m.dnsResolve.With(prom.Labels{
+		"target_host": host,
+	}).Observe(durationMs(elapsed))
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses found for \"%s\"", host)
+	}
+
+	if m.denyPrivate {
+		for _, addr := range addrs {
+			if isPrivateAddress(addr.IP) {
+				return nil, fmt.Errorf("\"%s\" %w %s", host, errBlockedAddress, addr.IP)
+			}
+		}
+	}
+
+	return addrs, nil
+}
+
+// isPrivateAddress indicates ip is in a private (RFC 1918 or RFC 4193), loopback, link-local, or
+// unspecified range, which should not be reached by resolving a public DNS name.
+func isPrivateAddress(ip net.IP) bool {
+	return ip.IsPrivate() ||
+		ip.IsLoopback() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified()
+}
+
+// preferredAddr picks the address of host to ping from its addrs, one in the preferred IP family if
+// there is one.
+func (m *pingMeasurer) preferredAddr(host string, addrs []net.IPAddr) (*net.IPAddr, error) {
+	for _, addr := range addrs {
+		if ipVersion(addr.IP) == m.ipVersion() {
+			return &addr, nil
+		}
+	}
+
+	if m.ipv6 {
+		return nil, fmt.Errorf("no IPv6 addresses found for \"%s\"", host)
+	}
+
+	return &addrs[0], nil
+}
+
+// ipVersion returns the IP address family which is preferred when resolving target hosts.
+func (m *pingMeasurer) ipVersion() string {
+	if m.ipv6 {
+		return IP_VERSION_6
+	}
+
+	return IP_VERSION_4
+}
+
+// newPinger creates a pinger which pings host at ipAddr.
+func (m *pingMeasurer) newPinger(host string, ipAddr *net.IPAddr) *probing.Pinger {
+	pinger := probing.New(host)
+	if m.ipv6 {
+		pinger.SetNetwork("ip6")
+	}
+	pinger.SetIPAddr(ipAddr)
+	pinger.Count = m.count
+	pinger.Interval = m.packetInterval
+	pinger.SetPrivileged(m.privileged)
+	pinger.Timeout = time.Duration(m.timeoutMs) * time.Millisecond
+	pinger.Size = m.size
+	pinger.TTL = m.ttl
+	pinger.SetTrafficClass(uint8(m.dscp << DSCP_SHIFT)) //nolint:gosec
+	pinger.Source = m.source
+	pinger.InterfaceName = m.interfaceName
+
+	if override, ok := m.overrides[targetKey(host)]; ok {
+		if override.Count != nil {
+			pinger.Count = *override.Count
+		}
+
+		if override.Size != nil {
+			pinger.Size = *override.Size
+		}
+
+		if override.TimeoutMs != nil {
+			pinger.Timeout = time.Duration(*override.TimeoutMs) * time.Millisecond
+		}
+	}
+
+	return pinger
+}
+
+// otherFamilyAddr returns the first of addrs which is not in the IP family version, or nil if
+// there is none.
+func otherFamilyAddr(addrs []net.IPAddr, version string) *net.IPAddr {
+	for _, addr := range addrs {
+		if ipVersion(addr.IP) != version {
+			return &addr
+		}
+	}
+
+	return nil
+}
+
+// measureOtherFamily pings target, the address of a dual-stack host in the IP family which is not
+// preferred. Only ping_rtt_ms and ping_failures_total are recorded, which are labeled by IP
+// version, the other metrics and backoff of the host follow the preferred family. It indicates if
+// the measurement completed, rather than being interrupted by ctx.
+func (m *pingMeasurer) measureOtherFamily(
+	ctx context.Context,
+	target pingTarget,
+) (measurement, bool) {
+	host := target.host
+	version := ipVersion(target.pinger.IPAddr().IP)
+	// Logged separately from the preferred family of the host
+	key := host + " IPv" + version
+
+	pinger, elapsed, err := m.runWithRetries(ctx, host, target.pinger)
+	if ctx.Err() != nil {
+		// Shutting down, the measurement was interrupted so don't record it
+		return measurement{}, false
+	}
+
+	reason := ""
+	if err != nil {
+		reason = pingFailureReason(err)
+	} else if pinger.Statistics().PacketsRecv == 0 {
+		err = errors.New("no packets received")
+		reason = noRepliesReason(pinger, elapsed)
+	}
+
+	if err != nil {
+		m.failureLog.failed(
+			key,
+			"failed to ping host",
+			"target_host", host,
+			"ip_version", version,
+			"error", err,
+		)
+		m.countFailure(ctx, host, version, reason)
+
+		result := failedMeasurement(PING_MEASUREMENT, host, err)
+		result.OtherIPVersion = version
+
+		return result, true
+	}
+
+	stats := pinger.Statistics()
+	rtt := float64(stats.AvgRtt.Milliseconds())
+	ip := pinger.IPAddr().String()
+
+	m.observeRtt(ctx, prom.Labels{
+		"target_host": host,
+		"ip":          ip,
+		"ip_version":  version,
+		"size":        strconv.Itoa(pinger.Size),
+		"ttl":         strconv.Itoa(pinger.TTL),
+		"dscp":        strconv.Itoa(int(pinger.TrafficClass()) >> DSCP_SHIFT),
+	}, rtt)
+	m.failureLog.succeeded(key, "target_host", host, "ip_version", version)
+	slog.Debug("ping measured", "target_host", host, "ip", ip, "rtt_ms", rtt)
+
+	result := successfulMeasurement(PING_MEASUREMENT, host, rtt)
+	result.OtherIPVersion = version
+
+	return result, true
+}
+
+// pingFailureReason classifies the error of a failed ping into one of the FAILURE_REASON values.
+func pingFailureReason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return FAILURE_REASON_TIMEOUT
+	case errors.Is(err, os.ErrPermission), errors.Is(err, syscall.EPERM):
+		return FAILURE_REASON_PERMISSION
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return FAILURE_REASON_NETWORK_UNREACHABLE
+	default:
+		return FAILURE_REASON_OTHER
+	}
+}
+
+// noRepliesReason classifies a ping by pinger which received no replies after running for elapsed.
+// pro-bing returns no error once the timeout elapses, so waiting the whole timeout is a timeout.
+func noRepliesReason(pinger *probing.Pinger, elapsed time.Duration) string {
+	if elapsed >= pinger.Timeout {
+		return FAILURE_REASON_TIMEOUT
+	}
+
+	return FAILURE_REASON_NO_PACKETS
+}
+
+// watchSequence counts the reply packets to pinger which arrive out of order or more than once,
+// only possible when it sends more than one packet.
+func (m *pingMeasurer) watchSequence(host string, pinger *probing.Pinger) {
+	if pinger.Count <= 1 {
+		return
+	}
+
+	// Created up front so the counters are exported as 0 before anything is counted
+	labels := prom.Labels{
+		"target_host": host,
+	}
+	outOfOrder := m.outOfOrder.With(labels)
+	duplicates := m.duplicates.With(labels)
+
+	// Replies are handled one at a time by the pinger, so need no lock
+	highestSeq := -1
+	pinger.OnRecv = func(packet *probing.Packet) {
+		if packet.Seq < highestSeq {
+			outOfOrder.Inc()
+			slog.Debug(
+				"ping reply received out of order",
+				"target_host", host,
+				"seq", packet.Seq,
+				"highest_seq", highestSeq,
+			)
+			return
+		}
+
+		highestSeq = packet.Seq
+	}
+	pinger.OnDuplicateRecv = func(packet *probing.Packet) {
+		duplicates.Inc()
+		slog.Debug("duplicate ping reply received", "target_host", host, "seq", packet.Seq)
+	}
+}
+
+// runWithRetries runs pinger, retrying up to the configured number of retries after an error. The
+// pinger which ran last is returned with how long it ran.
+func (m *pingMeasurer) runWithRetries(
+	ctx context.Context,
+	host string,
+	pinger *probing.Pinger,
+) (*probing.Pinger, time.Duration, error) {
+	m.warmUp(ctx, host, pinger.IPAddr())
+
+	m.watchSequence(host, pinger)
+	start := time.Now()
+	err := m.runPinger(ctx, host, pinger)
+	for attempt := 1; err != nil && attempt <= m.retries; attempt++ {
+		slog.Debug(
+			"retrying failed ping",
+			"target_host", host,
+			"attempt", attempt,
+			"retries", m.retries,
+			"error", err,
+		)
+
+		select {
+		case <-ctx.Done():
+			return pinger, time.Since(start), ctx.Err()
+		case <-time.After(time.Duration(PING_RETRY_DELAY_MS) * time.Millisecond):
+		}
+
+		// A pinger can only be run once
+		pinger = m.newPinger(host, pinger.IPAddr())
+		m.watchSequence(host, pinger)
+		start = time.Now()
+		err = m.runPinger(ctx, host, pinger)
+	}
+
+	return pinger, time.Since(start), err
+}
+
+// warmUp sends a single ping to ipAddr of host which is not recorded, if enabled and it is the
+// first measurement of host.
+func (m *pingMeasurer) warmUp(ctx context.Context, host string, ipAddr *net.IPAddr) {
+	if !m.warmup {
+		return
+	}
+
+	m.warmedUpLock.Lock()
+	warmedUp := m.warmedUp[host]
+	m.warmedUp[host] = true
+	m.warmedUpLock.Unlock()
+
+	if warmedUp {
+		return
+	}
+
+	pinger := m.newPinger(host, ipAddr)
+	pinger.Count = 1
+	err := m.runPinger(ctx, host, pinger)
+	slog.Debug(
+		"sent warmup ping",
+		"target_host", host,
+		"ip", ipAddr.String(),
+		"packets_received", pinger.Statistics().PacketsRecv,
+		"error", err,
+	)
+}
+
+// measureAll pings every target once.
+func (m *pingMeasurer) measureAll(ctx context.Context) []measurement {
+	return m.measure(ctx, m.currentTargets())
+}
+
+// measure pings the targets once.
+func (m *pingMeasurer) measure(ctx context.Context, targets []Target) []measurement {
+	results := []measurement{}
+	pingTargets := []pingTarget{}
+	for _, target := range targets {
+		host := target.Host
+
+		// Hosts share an interval in fallover mode
+		intervalMs := target.IntervalMs
+		if m.fallover {
+			intervalMs = m.intervalMs
+		}
+
+		if m.backoff.skip(host) {
+			slog.Debug("skipping measurement of failing host while backing off", "target_host", host)
+			if m.holdPrimary(host, targets) {
+				break
+			}
+			continue
+		}
+
+		// Resolve explicitly so resolution failures can be told apart from ping failures
+		addrs, err := m.lookup(ctx, host)
+		var ipAddr *net.IPAddr
+		if err == nil {
+			ipAddr, err = m.preferredAddr(host, addrs)
+		}
+		if ctx.Err() != nil {
+			return results
+		}
+		if err != nil {
+			reason := FAILURE_REASON_RESOLVE
+			msg := "failed to resolve host"
+			if errors.Is(err, errBlockedAddress) {
+				reason = FAILURE_REASON_BLOCKED
+				msg = "refusing to ping host which resolved to a private address"
+			}
+
+			m.failureLog.failed(host, msg, "target_host", host, "error", err)
+			m.dnsFailures.With(prom.Labels{
+				"target_host": host,
+				"reason":      reason,
+			}).Inc()
+			m.recordFailure(host, intervalMs)
+			m.countFailure(ctx, host, m.ipVersion(), reason)
+			results = append(results, failedMeasurement(PING_MEASUREMENT, host, err))
+			if m.holdPrimary(host, targets) {
+				break
+			}
+			continue
+		}
+
+		pingTargets = append(pingTargets, pingTarget{
+			host:       host,
+			intervalMs: intervalMs,
+			pinger:     m.newPinger(host, ipAddr),
+		})
+
+		// After the preferred family, which decides fallover and the active target
+		if m.dualStack {
+			version := ipVersion(ipAddr.IP)
+			if otherAddr := otherFamilyAddr(addrs, version); otherAddr != nil {
+				pingTargets = append(pingTargets, pingTarget{
+					host:        host,
+					intervalMs:  intervalMs,
+					otherFamily: true,
+					pinger:      m.newPinger(host, otherAddr),
+				})
+			} else {
+				slog.Debug(
+					"target host only has addresses of one IP family",
+					"target_host", host,
+					"ip_version", version,
+				)
+			}
+		}
+	}
+
+	// The host fallover stopped at, whose other IP family is still measured
+	stoppedAt := ""
+	for _, target := range pingTargets {
+		if len(stoppedAt) > 0 && (!target.otherFamily || target.host != stoppedAt) {
+			break
+		}
+
+		if target.otherFamily {
+			result, ok := m.measureOtherFamily(ctx, target)
+			if !ok {
+				return results
+			}
+
+			results = append(results, result)
+			continue
+		}
+
+		host := target.host
+		version := ipVersion(target.pinger.IPAddr().IP)
+
+		pinger, elapsed, err := m.runWithRetries(ctx, host, target.pinger)
+		if ctx.Err() != nil {
+			// Shutting down, the measurement was interrupted so don't record it
+			return results
+		}
+		if err != nil {
+			// Failed to ping, don't record ping statistics, but do record the failure
+			m.failureLog.failed(host, "failed to ping host", "target_host", host, "error", err)
+			m.recordFailure(host, target.intervalMs)
+			m.countFailure(ctx, host, version, pingFailureReason(err))
+			m.packetLoss.With(prom.Labels{
+				"target_host": host,
+			}).Set(100)
+			results = append(results, failedMeasurement(PING_MEASUREMENT, host, err))
+			if m.holdPrimary(host, targets) {
+				stoppedAt = host
+			}
+			continue
+		}
+
+		// Record ping round trip time
+		stats := pinger.Statistics()
+
+		m.packetsSent.With(prom.Labels{
+			"target_host": host,
+		}).Add(float64(stats.PacketsSent))
+		m.packetsRecv.With(prom.Labels{
+			"target_host": host,
+		}).Add(float64(stats.PacketsRecv))
+
+		// No packets received is always complete loss, even if no packets could be sent
+		packetLoss := stats.PacketLoss
+		if stats.PacketsRecv == 0 {
+			packetLoss = 100
+		}
+		m.packetLoss.With(prom.Labels{
+			"target_host": host,
+		}).Set(packetLoss)
+
+		// Check if any packets were received
+		if stats.PacketsRecv == 0 {
+			// Ping was unsuccessful
+			m.failureLog.failed(host, "ping failed, no packets received", "target_host", host)
+			m.recordFailure(host, target.intervalMs)
+			m.countFailure(ctx, host, version, noRepliesReason(pinger, elapsed))
+			results = append(
+				results,
+				failedMeasurement(PING_MEASUREMENT, host, errors.New("no packets received")),
+			)
+			if m.holdPrimary(host, targets) {
+				stoppedAt = host
+			}
+			continue // Skip recording RTT
+		}
+
+		rtt := float64(stats.AvgRtt.Milliseconds())
+		ip := pinger.IPAddr().String()
+
+		m.observeRtt(ctx, prom.Labels{
+			"target_host": host,
+			"ip":          ip,
+			"ip_version":  version,
+			"size":        strconv.Itoa(pinger.Size),
+			"ttl":         strconv.Itoa(pinger.TTL),
+			"dscp":        strconv.Itoa(int(pinger.TrafficClass()) >> DSCP_SHIFT),
+		}, rtt)
+
+		labels := prom.Labels{
+			"target_host": host,
+		}
+		m.rttMin.With(labels).Set(durationMs(stats.MinRtt))
+		m.rttMax.With(labels).Set(durationMs(stats.MaxRtt))
+		m.rttStdDev.With(labels).Set(durationMs(stats.StdDevRtt))
+		m.recordJitter(host, durationMs(stats.AvgRtt))
+		m.recordEwma(host, durationMs(stats.AvgRtt))
+
+		if m.history != nil {
+			m.history.record(host, rtt, time.Now())
+		}
+
+		// Still reachable so still successful for fallover, only counted as a failure to alert on
+		if m.maxRttMs > 0 && durationMs(stats.AvgRtt) > float64(m.maxRttMs) {
+			m.countFailure(ctx, host, version, FAILURE_REASON_SLOW)
+			slog.Debug(
+				"ping slower than the maximum round trip time",
+				"target_host", host,
+				"rtt_ms", durationMs(stats.AvgRtt),
+				"max_rtt_ms", m.maxRttMs,
+			)
+		}
+
+		m.recordSuccess(host)
+		m.failureLog.succeeded(host, "target_host", host)
+		slog.Debug("ping measured", "target_host", host, "ip", ip, "rtt_ms", rtt)
+		results = append(results, successfulMeasurement(PING_MEASUREMENT, host, rtt))
+
+		// If in fallover mode
+		if m.fallover {
+			// We just measured one host successfully so stop measuring
+			stoppedAt = host
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// PPROF_PATH is the path prefix under which the Go pprof debug handlers are served.
+const PPROF_PATH string = "/debug/pprof/"
+
+// pprofHandler returns a handler serving the Go pprof debug handlers under PPROF_PATH.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PPROF_PATH, pprof.Index)
+	mux.HandleFunc(PPROF_PATH+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(PPROF_PATH+"profile", withoutWriteTimeout(pprof.Profile))
+	mux.HandleFunc(PPROF_PATH+"symbol", pprof.Symbol)
+	mux.HandleFunc(PPROF_PATH+"trace", withoutWriteTimeout(pprof.Trace))
+
+	return mux
+}
+
+// withoutWriteTimeout lets handler respond after the server write timeout, as CPU profiles and
+// traces take 30 seconds by default. pprof refuses durations longer than the write timeout of the
+// server in the request context, so handler is given a server without one.
+func withoutWriteTimeout(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			slog.Warn("failed to clear the write deadline of a pprof request", "error", err)
+		}
+
+		ctx := context.WithValue(r.Context(), http.ServerContextKey, &http.Server{})
+		handler(w, r.WithContext(ctx))
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// DEFAULT_PUSH_JOB is the job label metrics are pushed to the Pushgateway with.
+const DEFAULT_PUSH_JOB string = "net-test"
+
+// PUSH_TIMEOUT_MS is the number of milliseconds before a push to the Pushgateway will timeout. 10
+// seconds.
+const PUSH_TIMEOUT_MS int = 10000
+
+// pusher periodically pushes metrics to a Prometheus Pushgateway.
+type pusher struct {
+	// url is the address of the Pushgateway.
+	url string
+
+	// intervalMs is the number of milliseconds to wait between pushes.
+	intervalMs int
+
+	// pusher pushes the gathered metrics under the job label.
+	pusher *push.Pusher
+}
+
+// newPusher creates a pusher which pushes the metrics gathered by gatherer to the Pushgateway at
+// url with the job label.
+func newPusher(url string, job string, intervalMs int, gatherer prom.Gatherer) *pusher {
+	return &pusher{
+		url:        url,
+		intervalMs: intervalMs,
+		pusher: push.New(url, job).
+			Gatherer(gatherer).
+			Client(&http.Client{
+				Timeout: time.Duration(PUSH_TIMEOUT_MS) * time.Millisecond,
+			}),
+	}
+}
+
+// run pushes metrics until ctx is done, sleeping for the interval between each. A failed push is
+// logged and retried on the next interval.
+func (p *pusher) run(ctx context.Context) {
+	for {
+		// Sleep before pushing so the first push includes measurements
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(p.intervalMs) * time.Millisecond):
+		}
+
+		// Replaces all metrics previously pushed with the job label
+		if err := p.pusher.PushContext(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			slog.Warn("failed to push metrics to Pushgateway", "url", p.url, "error", err)
+			continue
+		}
+
+		slog.Debug("pushed metrics to Pushgateway", "url", p.url)
+	}
+}
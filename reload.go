@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+// restartRequest asks a measurerGroup to apply changes while its measurers are stopped.
+type restartRequest struct {
+	// apply changes the measurers, it is called once they have all stopped.
+	apply func()
+
+	// applied is closed once apply has returned.
+	applied chan struct{}
+}
+
+// measurerGroup runs measurers which depend on each other, so they can be stopped and started again
+// together when a reloaded config file changes them.
+type measurerGroup struct {
+	measurers []measurer
+	restarts  chan restartRequest
+
+	// lock is held for writing while a restart is applied, so measureAll never measures while
+	// options of the measurers are replaced.
+	lock sync.RWMutex
+}
+
+// newMeasurerGroup creates a measurerGroup of measurers.
+func newMeasurerGroup(measurers ...measurer) *measurerGroup {
+	return &measurerGroup{
+		measurers: measurers,
+		restarts:  make(chan restartRequest),
+	}
+}
+
+// run performs measurements with every measurer until ctx is done, stopping them all while a
+// restart is applied.
+func (g *measurerGroup) run(ctx context.Context) {
+	for {
+		runCtx, cancel := context.WithCancel(ctx)
+
+		var wg sync.WaitGroup
+		for _, m := range g.measurers {
+			wg.Go(func() {
+				m.run(runCtx)
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			cancel()
+			wg.Wait()
+			return
+		case request := <-g.restarts:
+			cancel()
+			wg.Wait()
+			g.lock.Lock()
+			request.apply()
+			g.lock.Unlock()
+			close(request.applied)
+		}
+	}
+}
+
+// measureAll measures every target of every measurer once.
+func (g *measurerGroup) measureAll(ctx context.Context) []measurement {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	results := []measurement{}
+	for _, m := range g.measurers {
+		results = append(results, m.measureAll(ctx)...)
+	}
+
+	return results
+}
+
+// restart stops every measurer, calls apply, then starts them again. It returns once apply has
+// been called, or false without calling apply if ctx is done first.
+func (g *measurerGroup) restart(ctx context.Context, apply func()) bool {
+	request := restartRequest{
+		apply:   apply,
+		applied: make(chan struct{}),
+	}
+
+	select {
+	case g.restarts <- request:
+	case <-ctx.Done():
+		return false
+	}
+
+	<-request.applied
+
+	return true
+}
+
+// pingsNeedRestart indicates pings must be stopped and started again to apply the changes between
+// previous and next, changes to only the target hosts are applied while running.
+func pingsNeedRestart(previous configSettings, next configSettings) bool {
+	if previous.pingMs != next.pingMs ||
+		previous.pingCount != next.pingCount ||
+		previous.fallover != next.fallover {
+		return true
+	}
+
+	// Interval, priority, and alias overrides are applied to the target hosts, only the others are
+	// read while pinging
+	for _, host := range changedOverrides(previous.overrides, next.overrides) {
+		before, after := previous.overrides[host], next.overrides[host]
+		if !reflect.DeepEqual(before.Count, after.Count) ||
+			!reflect.DeepEqual(before.Size, after.Size) ||
+			!reflect.DeepEqual(before.TimeoutMs, after.TimeoutMs) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// changedOverrides returns the sorted hosts whose overrides differ between previous and next. A
+// host without overrides is the same as one which is not present.
+func changedOverrides(previous map[string]TargetConfig, next map[string]TargetConfig) []string {
+	all := slices.AppendSeq(slices.Collect(maps.Keys(previous)), maps.Keys(next))
+	slices.Sort(all)
+
+	hosts := []string{}
+	for _, host := range slices.Compact(all) {
+		before, after := previous[host], next[host]
+		before.Host, after.Host = "", ""
+		if !reflect.DeepEqual(before, after) {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts
+}
+
+// settingsChanges returns key value pairs describing each of the settings which differ between
+// previous and next, for logging.
+func settingsChanges(previous configSettings, next configSettings) []any {
+	changes := []any{}
+	if !slices.Equal(previous.targetHosts, next.targetHosts) {
+		changes = append(changes, "target_hosts", next.targetHosts)
+	}
+
+	if hosts := changedOverrides(previous.overrides, next.overrides); len(hosts) > 0 {
+		changes = append(changes, "changed_target_overrides", hosts)
+	}
+
+	if previous.pingMs != next.pingMs {
+		changes = append(changes, "ping_interval_ms", next.pingMs)
+	}
+
+	if previous.pingCount != next.pingCount {
+		changes = append(changes, "ping_count", next.pingCount)
+	}
+
+	if previous.fallover != next.fallover {
+		changes = append(changes, "fallover", next.fallover)
+	}
+
+	if previous.all != next.all {
+		changes = append(changes, "all", next.all)
+	}
+
+	return changes
+}
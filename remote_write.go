@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	prom "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DEFAULT_REMOTE_WRITE_INTERVAL_MS is the default number of milliseconds between remote writes. 10
+// seconds.
+const DEFAULT_REMOTE_WRITE_INTERVAL_MS int = 10000
+
+// REMOTE_WRITE_TIMEOUT_MS is the number of milliseconds before a remote write will timeout. 10
+// seconds.
+const REMOTE_WRITE_TIMEOUT_MS int = 10000
+
+// REMOTE_WRITE_VERSION is the version of the Prometheus remote write protocol which is sent.
+const REMOTE_WRITE_VERSION string = "0.1.0"
+
+// remoteWriteLabel is a label of a remote write time series.
+type remoteWriteLabel struct {
+	name  string
+	value string
+}
+
+// remoteWriteSeries is a time series with a single sample to remote write.
+type remoteWriteSeries struct {
+	labels []remoteWriteLabel
+	value  float64
+}
+
+// parseRemoteWriteURL parses an http or https remote write URL.
+func parseRemoteWriteURL(value string) (string, error) {
+	endpoint, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid remote write URL \"%s\": %w", value, err)
+	}
+
+	if (endpoint.Scheme != "http" && endpoint.Scheme != "https") || len(endpoint.Host) == 0 {
+		return "", fmt.Errorf(
+			"invalid remote write URL \"%s\": must be an http or https URL, ie. http://mimir:9009/api/v1/push",
+			value,
+		)
+	}
+
+	return endpoint.String(), nil
+}
+
+// remoteWriter periodically sends metrics to a Prometheus remote write endpoint.
+type remoteWriter struct {
+	// url is the remote write endpoint.
+	url string
+
+	// bearerToken authenticates requests if not empty.
+	bearerToken string
+
+	// intervalMs is the number of milliseconds to wait between writes.
+	intervalMs int
+
+	// labels are added to every time series, in place of the labels added when scraped.
+	labels []remoteWriteLabel
+
+	gatherer prom.Gatherer
+	client   *http.Client
+}
+
+// newRemoteWriter creates a remoteWriter which sends the metrics gathered by gatherer to url with
+// the job and instance labels.
+func newRemoteWriter(
+	url string,
+	bearerToken string,
+	intervalMs int,
+	job string,
+	instance string,
+	gatherer prom.Gatherer,
+) *remoteWriter {
+	return &remoteWriter{
+		url:         url,
+		bearerToken: bearerToken,
+		intervalMs:  intervalMs,
+		labels: []remoteWriteLabel{
+			{name: "instance", value: instance},
+			{name: "job", value: job},
+		},
+		gatherer: gatherer,
+		client: &http.Client{
+			Timeout: time.Duration(REMOTE_WRITE_TIMEOUT_MS) * time.Millisecond,
+		},
+	}
+}
+
+// run writes metrics until ctx is done, sleeping for the interval between each. A failed write is
+// logged and the next interval writes the metrics at that time.
+func (w *remoteWriter) run(ctx context.Context) {
+	for {
+		// Sleep before writing so the first write includes measurements
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(w.intervalMs) * time.Millisecond):
+		}
+
+		if err := w.write(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			slog.Warn("failed to remote write metrics", "url", w.url, "error", err)
+			continue
+		}
+
+		slog.Debug("remote wrote metrics", "url", w.url)
+	}
+}
+
+// write sends a snapshot of the current value of every metric.
+func (w *remoteWriter) write(ctx context.Context) error {
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+
+	body := encodeWriteRequest(remoteWriteSeriesOf(families, w.labels), time.Now().UnixMilli())
+
+	request, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		w.url,
+		bytes.NewReader(snappy.Encode(nil, body)),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("User-Agent", "net-test/"+Version)
+	request.Header.Set("X-Prometheus-Remote-Write-Version", REMOTE_WRITE_VERSION)
+	if len(w.bearerToken) > 0 {
+		request.Header.Set("Authorization", "Bearer "+w.bearerToken)
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close() //nolint:errcheck
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status \"%s\"", response.Status)
+	}
+
+	return nil
+}
+
+// remoteWriteSeriesOf flattens families into a time series per sample, as they would be scraped.
+// Histograms and summaries become their _bucket or quantile, _sum, and _count series. extra labels
+// are added to every series which doesn't already have a label of the same name.
+func remoteWriteSeriesOf(
+	families []*dto.MetricFamily,
+	extra []remoteWriteLabel,
+) []remoteWriteSeries {
+	series := []remoteWriteSeries{}
+	for _, family := range families {
+		name := family.GetName()
+		for _, metric := range family.GetMetric() {
+			labels := []remoteWriteLabel{}
+			for _, pair := range metric.GetLabel() {
+				labels = append(labels, remoteWriteLabel{name: pair.GetName(), value: pair.GetValue()})
+			}
+
+			for _, label := range extra {
+				if !slices.ContainsFunc(labels, func(existing remoteWriteLabel) bool {
+					return existing.name == label.name
+				}) {
+					labels = append(labels, label)
+				}
+			}
+
+			add := func(name string, value float64, more ...remoteWriteLabel) {
+				all := append(slices.Clone(labels), more...)
+				all = append(all, remoteWriteLabel{name: "__name__", value: name})
+				series = append(series, remoteWriteSeries{labels: all, value: value})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, metric.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, metric.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				for _, bucket := range histogram.GetBucket() {
+					add(
+						name+"_bucket",
+						float64(bucket.GetCumulativeCount()),
+						remoteWriteLabel{name: "le", value: formatFloat(bucket.GetUpperBound())},
+					)
+				}
+				add(
+					name+"_bucket",
+					float64(histogram.GetSampleCount()),
+					remoteWriteLabel{name: "le", value: formatFloat(math.Inf(1))},
+				)
+				add(name+"_sum", histogram.GetSampleSum())
+				add(name+"_count", float64(histogram.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					add(
+						name,
+						quantile.GetValue(),
+						remoteWriteLabel{name: "quantile", value: formatFloat(quantile.GetQuantile())},
+					)
+				}
+				add(name+"_sum", summary.GetSampleSum())
+				add(name+"_count", float64(summary.GetSampleCount()))
+			default:
+				slog.Debug("not remote writing metric of unsupported type", "metric", name)
+			}
+		}
+	}
+
+	return series
+}
+
+// formatFloat formats value as in the Prometheus text format, ie. "+Inf".
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes series as a remote write WriteRequest protobuf message, each with a
+// single sample at timestampMs.
+func encodeWriteRequest(series []remoteWriteSeries, timestampMs int64) []byte {
+	// WriteRequest { repeated TimeSeries timeseries = 1; }
+	var request []byte
+	for _, s := range series {
+		// Receivers require labels sorted by name
+		slices.SortFunc(s.labels, func(a remoteWriteLabel, b remoteWriteLabel) int {
+			return cmp.Compare(a.name, b.name)
+		})
+
+		// TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+		var timeSeries []byte
+		for _, label := range s.labels {
+			// Label { string name = 1; string value = 2; }
+			var encoded []byte
+			encoded = protowire.AppendTag(encoded, 1, protowire.BytesType)
+			encoded = protowire.AppendString(encoded, label.name)
+			encoded = protowire.AppendTag(encoded, 2, protowire.BytesType) //nolint:mnd
+			encoded = protowire.AppendString(encoded, label.value)
+
+			timeSeries = protowire.AppendTag(timeSeries, 1, protowire.BytesType)
+			timeSeries = protowire.AppendBytes(timeSeries, encoded)
+		}
+
+		// Sample { double value = 1; int64 timestamp = 2; }
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType) //nolint:mnd
+		sample = protowire.AppendVarint(sample, uint64(timestampMs))  //nolint:gosec
+
+		timeSeries = protowire.AppendTag(timeSeries, 2, protowire.BytesType) //nolint:mnd
+		timeSeries = protowire.AppendBytes(timeSeries, sample)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, timeSeries)
+	}
+
+	return request
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// RTT_HISTORY_PATH is the path on which recent round trip times are served as JSON.
+const RTT_HISTORY_PATH string = "/api/rtt"
+
+// DEFAULT_RTT_HISTORY_SAMPLES is the default number of recent round trip times kept per target
+// host.
+const DEFAULT_RTT_HISTORY_SAMPLES int = 100
+
+// rttSample is a round trip time measured at a point in time.
+type rttSample struct {
+	// Timestamp is when the round trip time was measured.
+	Timestamp time.Time `json:"timestamp"`
+
+	// RttMs is the average round trip time in milliseconds.
+	RttMs float64 `json:"rtt_ms"`
+}
+
+// rttRing holds the most recent samples of a target host, overwriting the oldest once full.
+type rttRing struct {
+	samples []rttSample
+
+	// next is the index the next sample is written to.
+	next int
+
+	// full indicates every index holds a sample.
+	full bool
+}
+
+// ordered returns the samples from oldest to newest.
+func (r *rttRing) ordered() []rttSample {
+	if !r.full {
+		return slices.Clone(r.samples[:r.next])
+	}
+
+	return slices.Concat(r.samples[r.next:], r.samples[:r.next])
+}
+
+// rttHistory keeps the most recent round trip times of each target host in memory and serves them
+// read-only over HTTP.
+type rttHistory struct {
+	// size is the number of samples kept per target host.
+	size int
+
+	// lock guards rings which is updated by concurrent measurements and read by requests.
+	lock  sync.Mutex
+	rings map[string]*rttRing
+}
+
+// newRTTHistory creates an rttHistory which keeps size samples per target host.
+func newRTTHistory(size int) *rttHistory {
+	return &rttHistory{
+		size:  size,
+		rings: map[string]*rttRing{},
+	}
+}
+
+// record adds the round trip time rttMs of host measured at now, replacing the oldest sample if
+// size samples are already kept.
+func (h *rttHistory) record(host string, rttMs float64, now time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	ring, ok := h.rings[host]
+	if !ok {
+		ring = &rttRing{
+			samples: make([]rttSample, h.size),
+		}
+		h.rings[host] = ring
+	}
+
+	ring.samples[ring.next] = rttSample{
+		Timestamp: now,
+		RttMs:     rttMs,
+	}
+	ring.next = (ring.next + 1) % h.size
+	ring.full = ring.full || ring.next == 0
+}
+
+// snapshot returns a copy of the samples of each target host, from oldest to newest. If host is not
+// empty only its samples are returned.
+func (h *rttHistory) snapshot(host string) map[string][]rttSample {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	samples := map[string][]rttSample{}
+	for ringHost, ring := range h.rings {
+		if len(host) > 0 && ringHost != host {
+			continue
+		}
+
+		samples[ringHost] = ring.ordered()
+	}
+
+	return samples
+}
+
+// ServeHTTP responds with the samples of each target host as a JSON object keyed by target host,
+// or only those of the target host in the host query parameter.
+func (h *rttHistory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.snapshot(r.URL.Query().Get("host")))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// SECRET_FILE_WORLD_READABLE is the permission bit of a file which allows every user to read it.
+const SECRET_FILE_WORLD_READABLE os.FileMode = 0o004
+
+// readSecretFile reads a secret, ie. a password or token, from the file at path for option, so it
+// isn't passed on the command line where it is visible to every user. A trailing newline is
+// removed. A warning is logged if every user can read the file.
+func readSecretFile(option string, path string) (string, error) {
+	warnIfWorldReadable(option, path)
+
+	data, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file \"%s\": %w", path, err)
+	}
+
+	secret := strings.TrimRight(string(data), "\r\n")
+	if len(secret) == 0 {
+		return "", fmt.Errorf("secret file \"%s\" is empty", path)
+	}
+
+	return secret, nil
+}
+
+// warnIfWorldReadable logs a warning if every user can read the file at path for option, which
+// should only be readable by the user running net-test. Files which can't be checked are left to
+// fail when they are read.
+func warnIfWorldReadable(option string, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Debug("failed to check permissions of secret file", "option", option, "path", path, "error", err)
+		}
+		return
+	}
+
+	if info.Mode().Perm()&SECRET_FILE_WORLD_READABLE != 0 {
+		slog.Warn(
+			"secret file is readable by every user, restrict its permissions, ie. chmod 600",
+			"option", option,
+			"path", path,
+			"mode", info.Mode().Perm().String(),
+		)
+	}
+}
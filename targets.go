@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TARGETS_FILE_COMMENT_PREFIX starts a line which is ignored in a targets file.
+const TARGETS_FILE_COMMENT_PREFIX string = "#"
+
+// TARGET_INTERVAL_SEPARATOR separates a target host from its measurement interval, ie.
+// "1.1.1.1@2000".
+const TARGET_INTERVAL_SEPARATOR string = "@"
+
+// TARGET_ALIAS_SEPARATOR separates a target host from its alias, ie. "10.0.0.5=core-router".
+const TARGET_ALIAS_SEPARATOR string = "="
+
+// DEFAULT_MAX_CIDR_ADDRESSES is the default largest number of addresses a CIDR target expands to,
+// the size of an IPv4 /22.
+const DEFAULT_MAX_CIDR_ADDRESSES int = 1024
+
+// DEFAULT_TARGET_HOSTS are measured when no target hosts are provided, unless -no-default-targets
+// is set.
+var DEFAULT_TARGET_HOSTS = []string{
+	"1.1.1.1",
+	"8.8.8.8",
+	"google.com",
+	"wikipedia.org",
+}
+
+// Target is a host to measure and the options used to measure it.
+type Target struct {
+	// Host is the DNS name or IP address to measure.
+	Host string
+
+	// IntervalMs is the number of milliseconds between measurements of the host.
+	IntervalMs int
+
+	// Priority orders targets in fallover mode, higher priority targets are preferred.
+	Priority int
+
+	// Alias is a human friendly name for the host recorded with its metrics, the host if not given.
+	Alias string
+}
+
+// parseTarget parses a "host[=alias][@interval]" value, using defaultIntervalMs if no interval is
+// given and the host if no alias is given.
+func parseTarget(value string, defaultIntervalMs int) (Target, error) {
+	target := Target{
+		Host:       value,
+		IntervalMs: defaultIntervalMs,
+	}
+
+	host, interval, hasInterval := strings.Cut(value, TARGET_INTERVAL_SEPARATOR)
+	target.Host = host
+	if hasInterval {
+		intervalMs, err := strconv.Atoi(interval)
+		if err != nil || intervalMs <= 0 {
+			return Target{}, fmt.Errorf(
+				"invalid interval \"%s\" for target \"%s\": must be a positive number of milliseconds",
+				interval,
+				value,
+			)
+		}
+
+		target.IntervalMs = intervalMs
+	}
+
+	host, alias, hasAlias := strings.Cut(target.Host, TARGET_ALIAS_SEPARATOR)
+	if hasAlias && len(alias) == 0 {
+		return Target{}, fmt.Errorf("invalid target \"%s\": alias must not be empty", value)
+	}
+
+	target.Host = host
+	target.Alias = cmp.Or(alias, host)
+
+	if len(target.Host) == 0 {
+		return Target{}, fmt.Errorf("invalid target \"%s\": host must not be empty", value)
+	}
+
+	return target, nil
+}
+
+// parseTargets parses each "host[=alias][@interval]" value, see parseTarget.
+func parseTargets(values []string, defaultIntervalMs int) ([]Target, error) {
+	targets := make([]Target, 0, len(values))
+	for _, value := range values {
+		target, err := parseTarget(value, defaultIntervalMs)
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+// expandCIDRs replaces each "cidr[=alias][@interval]" value with a value for every usable host
+// address in the CIDR, keeping the alias and interval. The network and broadcast addresses of IPv4
+// CIDRs larger than a /31 are skipped. Values which are not CIDRs are kept as they are, CIDRs with
+// more than maxAddresses addresses are an error.
+func expandCIDRs(values []string, maxAddresses int) ([]string, error) {
+	expanded := make([]string, 0, len(values))
+	for _, value := range values {
+		host, interval, hasInterval := strings.Cut(value, TARGET_INTERVAL_SEPARATOR)
+		host, alias, hasAlias := strings.Cut(host, TARGET_ALIAS_SEPARATOR)
+		if !strings.Contains(host, "/") {
+			expanded = append(expanded, value)
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR target \"%s\": %w", value, err)
+		}
+		prefix = prefix.Masked()
+
+		hostBits := prefix.Addr().BitLen() - prefix.Bits()
+		if hostBits >= strconv.IntSize-1 || 1<<hostBits > maxAddresses {
+			return nil, fmt.Errorf(
+				"CIDR target \"%s\" is larger than the maximum of %d addresses, use a smaller CIDR or increase -max-cidr-addresses",
+				value,
+				maxAddresses,
+			)
+		}
+
+		suffix := ""
+		if hasAlias {
+			suffix = TARGET_ALIAS_SEPARATOR + alias
+		}
+		if hasInterval {
+			suffix += TARGET_INTERVAL_SEPARATOR + interval
+		}
+
+		// The first and last IPv4 addresses are the network and broadcast addresses, except in
+		// point to point /31s and single address /32s
+		skipEnds := prefix.Addr().Is4() && hostBits > 1
+
+		first := prefix.Addr()
+		for addr := first; addr.IsValid() && prefix.Contains(addr); addr = addr.Next() {
+			if skipEnds && (addr == first || !prefix.Contains(addr.Next())) {
+				continue
+			}
+
+			expanded = append(expanded, addr.String()+suffix)
+		}
+	}
+
+	return expanded, nil
+}
+
+// targetKey normalizes host so the same target written differently compares equal. DNS names are
+// case-insensitive and IP addresses are compared by value.
+func targetKey(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// dedupeTargets returns targets without any target whose host duplicates an earlier target, a
+// warning is logged for each duplicate dropped.
+func dedupeTargets(targets []Target) []Target {
+	seen := map[string]string{}
+	deduped := make([]Target, 0, len(targets))
+	for _, target := range targets {
+		key := targetKey(target.Host)
+		if first, ok := seen[key]; ok {
+			slog.Warn(
+				"dropping duplicate target host",
+				"target_host", target.Host,
+				"duplicate_of", first,
+			)
+			continue
+		}
+
+		seen[key] = target.Host
+		deduped = append(deduped, target)
+	}
+
+	return deduped
+}
+
+// sortTargetsByPriority orders targets from highest to lowest priority, targets with the same
+// priority keep their order.
+func sortTargetsByPriority(targets []Target) {
+	slices.SortStableFunc(targets, func(a Target, b Target) int {
+		return cmp.Compare(b.Priority, a.Priority)
+	})
+}
+
+// hostsOf returns the host of each target in order.
+func hostsOf(targets []Target) []string {
+	hosts := make([]string, 0, len(targets))
+	for _, target := range targets {
+		hosts = append(hosts, target.Host)
+	}
+
+	return hosts
+}
+
+// readTargetsFile reads target hosts from the newline delimited file at path. Whitespace around
+// each line is trimmed, blank lines and lines starting with TARGETS_FILE_COMMENT_PREFIX are
+// ignored.
+func readTargetsFile(path string) ([]string, error) {
+	file, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("failed to open targets file \"%s\": %w", path, err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	hosts := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, TARGETS_FILE_COMMENT_PREFIX) {
+			continue
+		}
+
+		hosts = append(hosts, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read targets file \"%s\": %w", path, err)
+	}
+
+	return hosts, nil
+}
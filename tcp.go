@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/proxy"
+)
+
+// TCP_TIMEOUT_MS is the number of milliseconds before a TCP connect attempt will timeout. 10
+// seconds.
+const TCP_TIMEOUT_MS int = 10000
+
+// TCP_MEASUREMENT is the type of TCP connect measurements.
+const TCP_MEASUREMENT string = "tcp"
+
+// TCP_PHASE_RESOLVE, TCP_PHASE_CONNECT, and TCP_PHASE_TLS are the phases of a TCP measurement
+// recorded by the phase label of tcp_connect_phase_ms: resolving the target host, the TCP
+// handshake, and the TLS handshake.
+const (
+	TCP_PHASE_RESOLVE string = "resolve"
+	TCP_PHASE_CONNECT string = "connect"
+	TCP_PHASE_TLS     string = "tls"
+)
+
+// tcpTarget is a host and port to which a TCP connection is made.
+type tcpTarget struct {
+	host string
+	port string
+}
+
+// parseTCPTargets parses "host:port" values into tcpTargets.
+func parseTCPTargets(values []string) ([]tcpTarget, error) {
+	targets := make([]tcpTarget, 0, len(values))
+	for _, value := range values {
+		host, port, err := net.SplitHostPort(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TCP target \"%s\": %w", value, err)
+		}
+
+		if len(host) == 0 || len(port) == 0 {
+			return nil, fmt.Errorf("invalid TCP target \"%s\": must be in the form host:port", value)
+		}
+
+		targets = append(targets, tcpTarget{
+			host: host,
+			port: port,
+		})
+	}
+
+	return targets, nil
+}
+
+// tcpMeasurer periodically opens TCP connections to targets and records the connect time, and
+// optionally the TLS handshake time.
+type tcpMeasurer struct {
+	// targets are the hosts and ports to connect to.
+	targets []tcpTarget
+
+	// intervalMs is the number of milliseconds to wait between measurements.
+	intervalMs int
+
+	// dialer opens connections, directly or through a proxy.
+	dialer proxy.ContextDialer
+
+	// tlsConfig enables a TLS handshake after each connection is opened if not nil. The server
+	// name is set to the target host.
+	tlsConfig *tls.Config
+
+	// heartbeat is beat after every measurement.
+	heartbeat *heartbeat
+
+	// intervalJitter randomizes the time between measurements.
+	intervalJitter intervalJitter
+
+	// compensateDrift starts measurements on a fixed cadence rather than waiting the interval after
+	// each one finishes.
+	compensateDrift bool
+
+	// failureLog collapses repeated warnings about persistently failing targets.
+	failureLog *failureLogger
+
+	connect  *prom.HistogramVec
+	phases   *prom.HistogramVec
+	failures *prom.CounterVec
+
+	handshake         *prom.HistogramVec
+	handshakeFailures *prom.CounterVec
+	certExpiry        *prom.GaugeVec
+}
+
+// newTCPMeasurer creates a tcpMeasurer and registers its Prometheus metrics. The TLS metrics are
+// only registered with a tlsConfig.
+func newTCPMeasurer(
+	targets []tcpTarget,
+	intervalMs int,
+	dialer proxy.ContextDialer,
+	tlsConfig *tls.Config,
+	metrics metricsOptions,
+) *tcpMeasurer {
+	m := &tcpMeasurer{
+		targets:    targets,
+		intervalMs: intervalMs,
+		dialer:     dialer,
+		tlsConfig:  tlsConfig,
+		failureLog: newFailureLogger(),
+		connect: prom.NewHistogramVec(
+			prom.HistogramOpts{
+				Namespace: metrics.namespace,
+				Name:      "tcp_connect_ms",
+				Help:      "Time to open a TCP connection to a target host and port in milliseconds",
+				Buckets: []float64{
+					0, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100,
+					200, 400, 600, 800, 1000,
+					5000, 10000,
+				},
+			},
+			[]string{"target_host", "port"},
+		),
+		phases: prom.NewHistogramVec(
+			prom.HistogramOpts{
+				Namespace: metrics.namespace,
+				Name:      "tcp_connect_phase_ms",
+				Help:      "Time of each phase of connecting to a target host and port in milliseconds, resolving the target host, the TCP handshake, and the TLS handshake",
+				Buckets: []float64{
+					0, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100,
+					200, 400, 600, 800, 1000,
+					5000, 10000,
+				},
+			},
+			[]string{"target_host", "port", "phase"},
+		),
+		failures: prom.NewCounterVec(
+			prom.CounterOpts{
+				Namespace: metrics.namespace,
+				Name:      "tcp_connect_failures_total",
+				Help:      "Failures to open a TCP connection to target hosts and ports",
+			},
+			[]string{"target_host", "port"},
+		),
+		handshake: prom.NewHistogramVec(
+			prom.HistogramOpts{
+				Namespace: metrics.namespace,
+				Name:      "tls_handshake_ms",
+				Help:      "Time to complete a TLS handshake with a target host and port after connecting in milliseconds",
+				Buckets: []float64{
+					0, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100,
+					200, 400, 600, 800, 1000,
+					5000, 10000,
+				},
+			},
+			[]string{"target_host", "port"},
+		),
+		handshakeFailures: prom.NewCounterVec(
+			prom.CounterOpts{
+				Namespace: metrics.namespace,
+				Name:      "tls_handshake_failures_total",
+				Help:      "Failures to complete a TLS handshake with target hosts and ports",
+			},
+			[]string{"target_host", "port"},
+		),
+		certExpiry: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: metrics.namespace,
+				Name:      "tls_cert_expiry_seconds",
+				Help:      "Seconds until the certificate presented by a target host and port expires, as of the last TLS handshake",
+			},
+			[]string{"target_host", "port"},
+		),
+	}
+
+	prom.MustRegister(m.connect)
+	prom.MustRegister(m.phases)
+	prom.MustRegister(m.failures)
+
+	if tlsConfig != nil {
+		prom.MustRegister(m.handshake)
+		prom.MustRegister(m.handshakeFailures)
+		prom.MustRegister(m.certExpiry)
+	}
+
+	return m
+}
+
+// run performs measurements until ctx is done, waiting for the interval between each.
+func (m *tcpMeasurer) run(ctx context.Context) {
+	timer := newIntervalTimer(
+		TCP_MEASUREMENT,
+		time.Duration(m.intervalMs)*time.Millisecond,
+		m.intervalJitter,
+		m.compensateDrift,
+	)
+	defer timer.stop()
+
+	for {
+		start := time.Now()
+		m.measure(ctx)
+		m.heartbeat.finished(start)
+
+		if !timer.wait(ctx) {
+			return
+		}
+	}
+}
+
+// measureAll connects to every target once.
+func (m *tcpMeasurer) measureAll(ctx context.Context) []measurement {
+	return m.measure(ctx)
+}
+
+// measure connects to each target once.
+func (m *tcpMeasurer) measure(ctx context.Context) []measurement {
+	results := []measurement{}
+	for _, target := range m.targets {
+		result, ok := m.measureTarget(ctx, target)
+		if !ok {
+			return results
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// measureTarget connects to target once, and performs a TLS handshake if enabled. It indicates if
+// the measurement completed, rather than being interrupted by ctx.
+func (m *tcpMeasurer) measureTarget(ctx context.Context, target tcpTarget) (measurement, bool) {
+	labels := prom.Labels{
+		"target_host": target.host,
+		"port":        target.port,
+	}
+	addr := net.JoinHostPort(target.host, target.port)
+
+	dialCtx, cancel := context.WithTimeout(
+		ctx,
+		time.Duration(TCP_TIMEOUT_MS)*time.Millisecond,
+	)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := m.dial(dialCtx, target)
+	if ctx.Err() != nil {
+		// Shutting down, the measurement was interrupted so don't record it
+		return measurement{}, false
+	}
+	if err != nil {
+		m.failureLog.failed(
+			addr,
+			"failed to open tcp connection",
+			"target_host", target.host,
+			"port", target.port,
+			"error", err,
+		)
+		m.failures.With(labels).Inc()
+		return failedMeasurement(TCP_MEASUREMENT, addr, err), true
+	}
+	connectMs := durationMs(time.Since(start))
+	m.connect.With(labels).Observe(connectMs)
+
+	if m.tlsConfig != nil {
+		conn, err = m.handshakeWith(dialCtx, conn, target, labels)
+		if ctx.Err() != nil {
+			_ = conn.Close()
+			return measurement{}, false
+		}
+		if err != nil {
+			_ = conn.Close()
+			m.failureLog.failed(
+				addr,
+				"failed to complete tls handshake",
+				"target_host", target.host,
+				"port", target.port,
+				"error", err,
+			)
+			m.handshakeFailures.With(labels).Inc()
+			return failedMeasurement(TCP_MEASUREMENT, addr, err), true
+		}
+	}
+
+	if err := conn.Close(); err != nil {
+		slog.Warn(
+			"failed to close tcp connection",
+			"target_host", target.host,
+			"port", target.port,
+			"error", err,
+		)
+	}
+
+	m.failureLog.succeeded(addr, "target_host", target.host, "port", target.port)
+	slog.Debug(
+		"tcp connect measured",
+		"target_host", target.host,
+		"port", target.port,
+		"connect_ms", connectMs,
+	)
+
+	return successfulMeasurement(TCP_MEASUREMENT, addr, connectMs), true
+}
+
+// dial opens a TCP connection to target, recording the time to resolve the target host and of the
+// TCP handshake as phases. The target host is only resolved separately when connecting directly
+// to a DNS name, otherwise the whole dial is the connect phase.
+func (m *tcpMeasurer) dial(ctx context.Context, target tcpTarget) (net.Conn, error) {
+	addr := net.JoinHostPort(target.host, target.port)
+
+	dialer, direct := m.dialer.(*net.Dialer)
+	if !direct || net.ParseIP(target.host) != nil {
+		start := time.Now()
+		conn, err := m.dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		m.observePhase(target, TCP_PHASE_CONNECT, start)
+
+		return conn, nil
+	}
+
+	start := time.Now()
+	ipAddrs, err := net.DefaultResolver.LookupIPAddr(ctx, target.host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve target host: %w", err)
+	}
+	m.observePhase(target, TCP_PHASE_RESOLVE, start)
+
+	// Each address is tried in turn, as when dialing the DNS name
+	start = time.Now()
+	for _, ipAddr := range ipAddrs {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(ipAddr.String(), target.port))
+		if err == nil {
+			m.observePhase(target, TCP_PHASE_CONNECT, start)
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// observePhase records the time since start of phase of a measurement of target.
+func (m *tcpMeasurer) observePhase(target tcpTarget, phase string, start time.Time) {
+	m.phases.With(prom.Labels{
+		"target_host": target.host,
+		"port":        target.port,
+		"phase":       phase,
+	}).Observe(durationMs(time.Since(start)))
+}
+
+// handshakeWith performs a TLS handshake with target over conn, recording the handshake time and
+// the expiry of the certificate presented, also when it fails verification. It returns the TLS
+// connection, which must be closed in place of conn.
+func (m *tcpMeasurer) handshakeWith(
+	ctx context.Context,
+	conn net.Conn,
+	target tcpTarget,
+	labels prom.Labels,
+) (net.Conn, error) {
+	config := m.tlsConfig.Clone()
+	config.ServerName = target.host
+
+	tlsConn := tls.Client(conn, config)
+	start := time.Now()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		// An expired or otherwise invalid certificate is when its expiry matters most
+		var verifyErr *tls.CertificateVerificationError
+		if errors.As(err, &verifyErr) {
+			m.recordCertExpiry(labels, verifyErr.UnverifiedCertificates)
+		}
+
+		return tlsConn, err
+	}
+	handshakeMs := durationMs(time.Since(start))
+
+	m.handshake.With(labels).Observe(handshakeMs)
+	m.observePhase(target, TCP_PHASE_TLS, start)
+	m.recordCertExpiry(labels, tlsConn.ConnectionState().PeerCertificates)
+
+	slog.Debug(
+		"tls handshake measured",
+		"target_host", target.host,
+		"port", target.port,
+		"handshake_ms", handshakeMs,
+	)
+
+	return tlsConn, nil
+}
+
+// recordCertExpiry records the time until the leaf of certificates, the first, expires.
+func (m *tcpMeasurer) recordCertExpiry(labels prom.Labels, certificates []*x509.Certificate) {
+	if len(certificates) > 0 {
+		m.certExpiry.With(labels).Set(time.Until(certificates[0].NotAfter).Seconds())
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TEXTFILE_NAME is the name of the file written in the -textfile directory.
+const TEXTFILE_NAME string = "net-test.prom"
+
+// TEXTFILE_EXCLUDED_PREFIXES are metric name prefixes not written to the textfile as they describe
+// this process, and would collide with the metrics node_exporter exposes about itself.
+var TEXTFILE_EXCLUDED_PREFIXES = []string{"go_", "process_"}
+
+// writeTextfile atomically writes the metrics gathered by gatherer in the Prometheus text
+// exposition format to TEXTFILE_NAME in dir, for the node_exporter textfile collector.
+func writeTextfile(dir string, gatherer prom.Gatherer) (string, error) {
+	path := filepath.Join(dir, TEXTFILE_NAME)
+
+	filtered := prom.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+
+		kept := make([]*dto.MetricFamily, 0, len(families))
+		for _, family := range families {
+			if !hasAnyPrefix(family.GetName(), TEXTFILE_EXCLUDED_PREFIXES) {
+				kept = append(kept, family)
+			}
+		}
+
+		return kept, err
+	})
+
+	// Written to a temporary file then renamed so node_exporter never reads a partial file
+	if err := prom.WriteToTextfile(path, filtered); err != nil {
+		return "", fmt.Errorf("failed to write textfile \"%s\": %w", path, err)
+	}
+
+	return path, nil
+}
+
+// hasAnyPrefix indicates if s starts with any of prefixes.
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
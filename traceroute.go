@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// TRACEROUTE_MEASUREMENT is the type of traceroute measurements.
+const TRACEROUTE_MEASUREMENT string = "traceroute"
+
+// DEFAULT_TRACEROUTE_INTERVAL_MS is the default number of milliseconds between traceroutes. 5
+// minutes.
+const DEFAULT_TRACEROUTE_INTERVAL_MS int = 300000
+
+// DEFAULT_TRACEROUTE_MAX_HOPS is the default largest number of hops a traceroute follows.
+const DEFAULT_TRACEROUTE_MAX_HOPS int = 30
+
+// TRACEROUTE_PROBE_TIMEOUT_MS is the number of milliseconds to wait for a hop to respond to a
+// traceroute probe. 2 seconds.
+const TRACEROUTE_PROBE_TIMEOUT_MS int = 2000
+
+// ICMPV4_PROTOCOL is the IP protocol number of ICMP.
+const ICMPV4_PROTOCOL int = 1
+
+// ICMPV6_PROTOCOL is the IP protocol number of ICMPv6.
+const ICMPV6_PROTOCOL int = 58
+
+// TRACEROUTE_PAYLOAD is the data sent in each traceroute probe.
+const TRACEROUTE_PAYLOAD string = "net-test traceroute"
+
+// tracerouteHop is a hop which responded to a traceroute probe.
+type tracerouteHop struct {
+	// number is the TTL of the probe the hop responded to, starting at 1.
+	number int
+
+	// ip is the address of the hop.
+	ip net.IP
+
+	// rtt is the time until the hop responded.
+	rtt time.Duration
+}
+
+// tracerouteMeasurer periodically traces the path to each ping target host by sending pings with
+// increasing TTLs, recording the latency to each hop which responds.
+type tracerouteMeasurer struct {
+	// pings provide the target hosts and how they are pinged.
+	pings *pingMeasurer
+
+	// intervalMs is the number of milliseconds to wait between traceroutes.
+	intervalMs int
+
+	// maxHops is the largest TTL sent before giving up on reaching a target host.
+	maxHops int
+
+	// heartbeat is beat after every measurement.
+	heartbeat *heartbeat
+
+	// intervalJitter randomizes the time between measurements.
+	intervalJitter intervalJitter
+
+	// compensateDrift starts measurements on a fixed cadence rather than waiting the interval after
+	// each one finishes.
+	compensateDrift bool
+
+	// failureLog collapses repeated warnings about persistently failing targets.
+	failureLog *failureLogger
+
+	hopRtt *prom.GaugeVec
+}
+
+// newTracerouteMeasurer creates a tracerouteMeasurer for the target hosts of pings and registers
+// its Prometheus metrics.
+func newTracerouteMeasurer(
+	pings *pingMeasurer,
+	intervalMs int,
+	maxHops int,
+	metrics metricsOptions,
+) *tracerouteMeasurer {
+	m := &tracerouteMeasurer{
+		pings:      pings,
+		intervalMs: intervalMs,
+		maxHops:    maxHops,
+		failureLog: newFailureLogger(),
+		hopRtt: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Namespace: metrics.namespace,
+				Name:      "traceroute_hop_rtt_ms",
+				Help:      "Round trip time to each hop on the path to a target host which responded in the last traceroute in milliseconds",
+			},
+			[]string{"target_host", "hop", "hop_ip"},
+		),
+	}
+
+	prom.MustRegister(m.hopRtt)
+
+	return m
+}
+
+// run performs measurements until ctx is done, waiting for the interval between each.
+func (m *tracerouteMeasurer) run(ctx context.Context) {
+	timer := newIntervalTimer(
+		TRACEROUTE_MEASUREMENT,
+		time.Duration(m.intervalMs)*time.Millisecond,
+		m.intervalJitter,
+		m.compensateDrift,
+	)
+	defer timer.stop()
+
+	for {
+		start := time.Now()
+		m.measure(ctx)
+		m.heartbeat.finished(start)
+
+		if !timer.wait(ctx) {
+			return
+		}
+	}
+}
+
+// measureAll traces the path to every target host once.
+func (m *tracerouteMeasurer) measureAll(ctx context.Context) []measurement {
+	return m.measure(ctx)
+}
+
+// measure traces the path to each target host once.
+func (m *tracerouteMeasurer) measure(ctx context.Context) []measurement {
+	results := []measurement{}
+	for _, target := range m.pings.currentTargets() {
+		host := target.Host
+
+		var hops []tracerouteHop
+		reached := false
+		ipAddr, err := m.pings.resolve(ctx, host)
+		if err == nil {
+			hops, reached, err = m.trace(ctx, host, ipAddr)
+		}
+		if ctx.Err() != nil {
+			// Shutting down, the measurement was interrupted so don't record it
+			return results
+		}
+		if err != nil {
+			m.failureLog.failed(host, "failed to traceroute host", "target_host", host, "error", err)
+			results = append(results, failedMeasurement(TRACEROUTE_MEASUREMENT, host, err))
+			continue
+		}
+
+		// Hops which no longer respond, or changed address, are no longer reported
+		m.hopRtt.DeletePartialMatch(prom.Labels{
+			"target_host": host,
+		})
+		for _, hop := range hops {
+			m.hopRtt.With(prom.Labels{
+				"target_host": host,
+				"hop":         strconv.Itoa(hop.number),
+				"hop_ip":      hop.ip.String(),
+			}).Set(durationMs(hop.rtt))
+		}
+
+		if !reached {
+			err := fmt.Errorf("target host not reached within %d hops", m.maxHops)
+			m.failureLog.failed(
+				host,
+				"failed to traceroute host",
+				"target_host", host,
+				"responding_hops", len(hops),
+				"error", err,
+			)
+			results = append(results, failedMeasurement(TRACEROUTE_MEASUREMENT, host, err))
+			continue
+		}
+
+		last := hops[len(hops)-1]
+		m.failureLog.succeeded(host, "target_host", host)
+		slog.Debug(
+			"traceroute measured",
+			"target_host", host,
+			"hops", last.number,
+			"responding_hops", len(hops),
+		)
+		results = append(
+			results,
+			successfulMeasurement(TRACEROUTE_MEASUREMENT, host, durationMs(last.rtt)),
+		)
+	}
+
+	return results
+}
+
+// trace sends pings to ipAddr of host with TTLs from 1 up to maxHops, until one is answered by
+// ipAddr itself. It returns the hops which responded and indicates if ipAddr was reached.
+func (m *tracerouteMeasurer) trace(
+	ctx context.Context,
+	host string,
+	ipAddr *net.IPAddr,
+) ([]tracerouteHop, bool, error) {
+	v6 := ipVersion(ipAddr.IP) == IP_VERSION_6
+
+	// Raw sockets are required, unprivileged ping sockets don't receive time exceeded errors
+	network, address := "ip4:icmp", "0.0.0.0"
+	if v6 {
+		network, address = "ip6:ipv6-icmp", "::"
+	}
+	if len(m.pings.source) > 0 {
+		address = m.pings.source
+	}
+
+	var conn *icmp.PacketConn
+	err := inNamespace(m.pings.namespaceOf(host), func() error {
+		var err error
+		conn, err = icmp.ListenPacket(network, address)
+		return err
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open icmp socket: %w", err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	// Sent from the same interface as pings
+	ifIndex, err := interfaceIndex(m.pings.interfaceName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Probes from concurrent traceroutes are told apart by their ID
+	id := rand.N(1 << 16) //nolint:mnd
+
+	hops := []tracerouteHop{}
+	for ttl := 1; ttl <= m.maxHops; ttl++ {
+		if ctx.Err() != nil {
+			return nil, false, ctx.Err()
+		}
+
+		if v6 {
+			err = conn.IPv6PacketConn().SetHopLimit(ttl)
+		} else {
+			err = conn.IPv4PacketConn().SetTTL(ttl)
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to set ttl: %w", err)
+		}
+
+		hop, reached, err := probeHop(conn, ipAddr, v6, id, ttl, ifIndex)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if hop != nil {
+			hops = append(hops, *hop)
+		}
+
+		if reached {
+			return hops, true, nil
+		}
+	}
+
+	return hops, false, nil
+}
+
+// probeHop sends a ping with sequence number ttl to ipAddr on conn, from the interface with
+// ifIndex if not 0, and waits for its echo reply or time exceeded error. It returns the hop which
+// responded, nil if none did before the timeout, and indicates if it was ipAddr itself.
+func probeHop(
+	conn *icmp.PacketConn,
+	ipAddr *net.IPAddr,
+	v6 bool,
+	id int,
+	ttl int,
+	ifIndex int,
+) (*tracerouteHop, bool, error) {
+	protocol := ICMPV4_PROTOCOL
+	var echoType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if v6 {
+		protocol = ICMPV6_PROTOCOL
+		echoType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	request := icmp.Message{
+		Type: echoType,
+		Body: &icmp.Echo{
+			ID:   id,
+			Seq:  ttl,
+			Data: []byte(TRACEROUTE_PAYLOAD),
+		},
+	}
+	data, err := request.Marshal(nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create icmp echo request: %w", err)
+	}
+
+	start := time.Now()
+	deadline := start.Add(time.Duration(TRACEROUTE_PROBE_TIMEOUT_MS) * time.Millisecond)
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, false, fmt.Errorf("failed to set icmp socket deadline: %w", err)
+	}
+
+	if err := writeICMP(conn, data, ipAddr, v6, ifIndex); err != nil {
+		return nil, false, fmt.Errorf("failed to send icmp echo request: %w", err)
+	}
+
+	response := make([]byte, UDP_MAX_RESPONSE_BYTES)
+	for {
+		n, peer, err := conn.ReadFrom(response)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, false, nil
+			}
+
+			return nil, false, fmt.Errorf("failed to receive icmp response: %w", err)
+		}
+		rtt := time.Since(start)
+
+		message, err := icmp.ParseMessage(protocol, response[:n])
+		if err != nil {
+			continue
+		}
+
+		peerAddr, ok := peer.(*net.IPAddr)
+		if !ok {
+			continue
+		}
+
+		hop := &tracerouteHop{
+			number: ttl,
+			ip:     peerAddr.IP,
+			rtt:    rtt,
+		}
+
+		switch body := message.Body.(type) {
+		case *icmp.Echo:
+			if message.Type == replyType && body.ID == id && body.Seq == ttl {
+				return hop, true, nil
+			}
+		case *icmp.TimeExceeded:
+			quotedID, quotedSeq, ok := quotedEcho(body.Data, v6)
+			if ok && quotedID == id && quotedSeq == ttl {
+				return hop, false, nil
+			}
+		}
+	}
+}
+
+// interfaceIndex returns the index of the network interface named name, or 0 to let the operating
+// system choose if name is empty.
+func interfaceIndex(name string) (int, error) {
+	if len(name) == 0 {
+		return 0, nil
+	}
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find interface: %w", err)
+	}
+
+	return iface.Index, nil
+}
+
+// writeICMP sends data to ipAddr on conn, from the interface with ifIndex if not 0.
+func writeICMP(conn *icmp.PacketConn, data []byte, ipAddr *net.IPAddr, v6 bool, ifIndex int) error {
+	var err error
+	switch {
+	case ifIndex == 0:
+		_, err = conn.WriteTo(data, ipAddr)
+	case v6:
+		_, err = conn.IPv6PacketConn().WriteTo(data, &ipv6.ControlMessage{IfIndex: ifIndex}, ipAddr)
+	default:
+		_, err = conn.IPv4PacketConn().WriteTo(data, &ipv4.ControlMessage{IfIndex: ifIndex}, ipAddr)
+	}
+
+	return err
+}
+
+// quotedEcho returns the ID and sequence number of the echo request quoted in data, the original
+// datagram of an ICMP error, and indicates if data was long enough to contain them.
+func quotedEcho(data []byte, v6 bool) (int, int, bool) {
+	headerBytes := IPV6_HEADER_BYTES
+	if !v6 {
+		if len(data) == 0 {
+			return 0, 0, false
+		}
+
+		// The IPv4 header length is in 4 byte words
+		headerBytes = int(data[0]&0x0f) * 4 //nolint:mnd
+	}
+
+	if len(data) < headerBytes+ICMP_HEADER_BYTES {
+		return 0, 0, false
+	}
+
+	echo := data[headerBytes:]
+
+	return int(binary.BigEndian.Uint16(echo[4:6])), int(binary.BigEndian.Uint16(echo[6:8])), true
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// UDP_MEASUREMENT is the type of UDP probe measurements.
+const UDP_MEASUREMENT string = "udp"
+
+// DEFAULT_UDP_TIMEOUT_MS is the default number of milliseconds to wait for a response to a UDP
+// probe. 5 seconds.
+const DEFAULT_UDP_TIMEOUT_MS int = 5000
+
+// UDP_PAYLOAD_SEPARATOR separates a UDP target host:port from the payload sent to it, ie.
+// "10.0.0.1:9000/ping".
+const UDP_PAYLOAD_SEPARATOR string = "/"
+
+// UDP_MAX_RESPONSE_BYTES is the largest UDP response which is read, the largest UDP datagram.
+const UDP_MAX_RESPONSE_BYTES int = 65535
+
+// udpTarget is a host and port to which a UDP probe is sent.
+type udpTarget struct {
+	host string
+	port string
+
+	// payload is sent in the probe datagram, it may be empty.
+	payload []byte
+}
+
+// parseUDPTargets parses "host:port[/payload]" values into udpTargets. The payload may contain Go
+// escape sequences, ie. "\x00" or "\n".
+func parseUDPTargets(values []string) ([]udpTarget, error) {
+	targets := make([]udpTarget, 0, len(values))
+	for _, value := range values {
+		addr, payloadValue, _ := strings.Cut(value, UDP_PAYLOAD_SEPARATOR)
+
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UDP target \"%s\": %w", value, err)
+		}
+
+		if len(host) == 0 || len(port) == 0 {
+			return nil, fmt.Errorf(
+				"invalid UDP target \"%s\": must be in the form host:port[/payload]",
+				value,
+			)
+		}
+
+		payload, err := parseUDPPayload(payloadValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload of UDP target \"%s\": %w", value, err)
+		}
+
+		targets = append(targets, udpTarget{
+			host:    host,
+			port:    port,
+			payload: payload,
+		})
+	}
+
+	return targets, nil
+}
+
+// parseUDPPayload interprets the Go escape sequences in value.
+func parseUDPPayload(value string) ([]byte, error) {
+	// Quotes inside the value would end the quoted string early, so escape them first
+	unquoted, err := strconv.Unquote(`"` + strings.ReplaceAll(value, `"`, `\"`) + `"`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid escape sequence: %w", err)
+	}
+
+	return []byte(unquoted), nil
+}
+
+// udpMeasurer periodically sends UDP probes to targets and records the time until a response.
+type udpMeasurer struct {
+	// targets are the hosts and ports to probe.
+	targets []udpTarget
+
+	// intervalMs is the number of milliseconds to wait between measurements.
+	intervalMs int
+
+	// timeoutMs is the number of milliseconds to wait for a response to each probe.
+	timeoutMs int
+
+	// expect must be contained in a response for it to count as successful, any response counts if
+	// empty.
+	expect []byte
+
+	// heartbeat is beat after every measurement.
+	heartbeat *heartbeat
+
+	// intervalJitter randomizes the time between measurements.
+	intervalJitter intervalJitter
+
+	// compensateDrift starts measurements on a fixed cadence rather than waiting the interval after
+	// each one finishes.
+	compensateDrift bool
+
+	// failureLog collapses repeated warnings about persistently failing targets.
+	failureLog *failureLogger
+
+	probe    *prom.HistogramVec
+	failures *prom.CounterVec
+}
+
+// newUDPMeasurer creates a udpMeasurer and registers its Prometheus metrics.
+func newUDPMeasurer(
+	targets []udpTarget,
+	intervalMs int,
+	timeoutMs int,
+	expect string,
+	metrics metricsOptions,
+) *udpMeasurer {
+	m := &udpMeasurer{
+		targets:    targets,
+		intervalMs: intervalMs,
+		timeoutMs:  timeoutMs,
+		expect:     []byte(expect),
+		failureLog: newFailureLogger(),
+		probe: prom.NewHistogramVec(
+			prom.HistogramOpts{
+				Namespace: metrics.namespace,
+				Name:      "udp_probe_ms",
+				Help:      "Time from sending a UDP probe to a target host and port until its response in milliseconds",
+				Buckets: []float64{
+					0, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100,
+					200, 400, 600, 800, 1000,
+					5000, 10000,
+				},
+			},
+			[]string{"target_host", "port"},
+		),
+		failures: prom.NewCounterVec(
+			prom.CounterOpts{
+				Namespace: metrics.namespace,
+				Name:      "udp_probe_failures_total",
+				Help:      "Failures of target hosts and ports to respond to UDP probes",
+			},
+			[]string{"target_host", "port"},
+		),
+	}
+
+	prom.MustRegister(m.probe)
+	prom.MustRegister(m.failures)
+
+	return m
+}
+
+// run performs measurements until ctx is done, waiting for the interval between each.
+func (m *udpMeasurer) run(ctx context.Context) {
+	timer := newIntervalTimer(
+		UDP_MEASUREMENT,
+		time.Duration(m.intervalMs)*time.Millisecond,
+		m.intervalJitter,
+		m.compensateDrift,
+	)
+	defer timer.stop()
+
+	for {
+		start := time.Now()
+		m.measure(ctx)
+		m.heartbeat.finished(start)
+
+		if !timer.wait(ctx) {
+			return
+		}
+	}
+}
+
+// measureAll probes every target once.
+func (m *udpMeasurer) measureAll(ctx context.Context) []measurement {
+	return m.measure(ctx)
+}
+
+// measure probes each target once.
+func (m *udpMeasurer) measure(ctx context.Context) []measurement {
+	results := []measurement{}
+	for _, target := range m.targets {
+		labels := prom.Labels{
+			"target_host": target.host,
+			"port":        target.port,
+		}
+		addr := net.JoinHostPort(target.host, target.port)
+
+		probeCtx, cancel := context.WithTimeout(
+			ctx,
+			time.Duration(m.timeoutMs)*time.Millisecond,
+		)
+		elapsed, err := m.send(probeCtx, addr, target.payload)
+		cancel()
+		if ctx.Err() != nil {
+			// Shutting down, the measurement was interrupted so don't record it
+			return results
+		}
+		if err != nil {
+			m.failureLog.failed(
+				addr,
+				"failed to probe udp",
+				"target_host", target.host,
+				"port", target.port,
+				"error", err,
+			)
+			m.failures.With(labels).Inc()
+			results = append(results, failedMeasurement(UDP_MEASUREMENT, addr, err))
+			continue
+		}
+
+		probeMs := durationMs(elapsed)
+
+		m.probe.With(labels).Observe(probeMs)
+		m.failureLog.succeeded(addr, "target_host", target.host, "port", target.port)
+		slog.Debug(
+			"udp probe measured",
+			"target_host", target.host,
+			"port", target.port,
+			"probe_ms", probeMs,
+		)
+		results = append(results, successfulMeasurement(UDP_MEASUREMENT, addr, probeMs))
+	}
+
+	return results
+}
+
+// send sends payload to addr and returns the time until a response containing the expected
+// substring is received. Responses without it are ignored until ctx is done.
+func (m *udpMeasurer) send(ctx context.Context, addr string, payload []byte) (time.Duration, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open udp socket: %w", err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return 0, fmt.Errorf("failed to set udp socket deadline: %w", err)
+		}
+	}
+
+	start := time.Now()
+	if _, err := conn.Write(payload); err != nil {
+		return 0, fmt.Errorf("failed to send udp probe: %w", err)
+	}
+
+	response := make([]byte, UDP_MAX_RESPONSE_BYTES)
+	for {
+		n, err := conn.Read(response)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return 0, errors.New("no matching response before timeout")
+			}
+
+			return 0, fmt.Errorf("failed to receive udp response: %w", err)
+		}
+
+		if bytes.Contains(response[:n], m.expect) {
+			return time.Since(start), nil
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Version, Commit, and BuildDate describe the build, they are set at build time with:
+//
+//	go build -ldflags "-X main.Version=<version> -X main.Commit=<commit> -X main.BuildDate=<date>"
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// versionString describes the build in a human readable form.
+func versionString() string {
+	return fmt.Sprintf(
+		"net-test %s (commit %s, built %s, %s)",
+		Version,
+		Commit,
+		BuildDate,
+		runtime.Version(),
+	)
+}
+
+// registerBuildInfo registers the net_test_build_info metric which always has the value 1.
+func registerBuildInfo(metrics metricsOptions) {
+	buildInfo := prom.NewGaugeVec(
+		prom.GaugeOpts{
+			Namespace: metrics.namespace,
+			Name:      "net_test_build_info",
+			Help:      "A metric with a constant '1' value labeled by version, revision, build date, and go version from which net-test was built",
+		},
+		[]string{"version", "revision", "build_date", "go_version"},
+	)
+	prom.MustRegister(buildInfo)
+
+	buildInfo.With(prom.Labels{
+		"version":    Version,
+		"revision":   Commit,
+		"build_date": BuildDate,
+		"go_version": runtime.Version(),
+	}).Set(1)
+}
+
+// registerStartTime registers the net_test_start_time_seconds metric set to the Unix time net-test
+// started at, so uptime and restarts can be derived from it.
+func registerStartTime(metrics metricsOptions) {
+	startTime := prom.NewGauge(
+		prom.GaugeOpts{
+			Namespace: metrics.namespace,
+			Name:      "net_test_start_time_seconds",
+			Help:      "Unix time at which net-test started in seconds",
+		},
+	)
+	prom.MustRegister(startTime)
+
+	startTime.SetToCurrentTime()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WATCH_DEBOUNCE_MS is the number of milliseconds to wait for further changes to a watched file
+// before calling its change handler, editors and configuration management often write a file in
+// several steps.
+const WATCH_DEBOUNCE_MS int = 250
+
+// watchFile calls onChange whenever the file at path is written, created, or replaced, until ctx is
+// done. The parent directory is watched so the file being replaced by renaming over it is detected.
+func watchFile(ctx context.Context, path string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer watcher.Close() //nolint:errcheck
+
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to watch \"%s\": %w", filepath.Dir(path), err)
+	}
+
+	debounce := time.NewTimer(0)
+	<-debounce.C
+
+	for {
+		select {
+		case <-ctx.Done():
+			debounce.Stop()
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			if filepath.Clean(event.Name) != path ||
+				!event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+
+			debounce.Reset(time.Duration(WATCH_DEBOUNCE_MS) * time.Millisecond)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+
+			slog.Warn("error watching file for changes", "path", path, "error", err)
+		case <-debounce.C:
+			onChange()
+		}
+	}
+}